@@ -7,9 +7,11 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/vikram-suki/radix/v3/resp"
 	"github.com/vikram-suki/radix/v3/resp/resp2"
@@ -41,6 +43,52 @@ type CmdAction interface {
 	resp.Unmarshaler
 }
 
+// PipelineableAction is a CmdAction which can be pipelined automatically with
+// other concurrent Actions by a Pool (see PoolPipelineWindow). Its commands are
+// marshaled together with those of the other Actions, and its response is
+// unmarshaled using a single UnmarshalRESP call, so Run is not called.
+//
+// NOTE that the Actions which are returned by Cmd, FlatCmd, and EvalScript.Cmd,
+// as well as a Pipeline of PipelineableActions, all implicitly implement this
+// interface.
+type PipelineableAction interface {
+	CmdAction
+
+	// PipelineCmds returns the names of the commands the Action performs, e.g.
+	// []string{"GET"}, which are used to exclude blocking commands from being
+	// pipelined. If nil is returned the Action won't be pipelined.
+	// The returned slice must not be modified.
+	PipelineCmds() []string
+}
+
+// LongRunningAction is an Action which may take a long time to complete, e.g.
+// because it performs blocking commands. A Pool with blocking connections (see
+// PoolBlockingConns) performs it on those, rather than on its normal ones.
+type LongRunningAction interface {
+	Action
+
+	// LongRunning returns the longest time the Action is expected to take, by
+	// which the read timeout of the Conn it is performed on is extended. If
+	// zero is returned the Conn has no read timeout while the Action is
+	// performed.
+	LongRunning() time.Duration
+}
+
+type longRunningAction struct {
+	Action
+	maxDuration time.Duration
+}
+
+// LongRunning wraps the given Action so that it implements LongRunningAction,
+// with its LongRunning method returning maxDuration.
+func LongRunning(a Action, maxDuration time.Duration) Action {
+	return longRunningAction{Action: a, maxDuration: maxDuration}
+}
+
+func (lra longRunningAction) LongRunning() time.Duration {
+	return lra.maxDuration
+}
+
 var noKeyCmds = map[string]bool{
 	"SENTINEL": true,
 
@@ -298,6 +346,23 @@ func (c *cmdAction) Run(conn Conn) error {
 	return conn.Decode(c)
 }
 
+func (c *cmdAction) PipelineCmds() []string {
+	return []string{c.cmd}
+}
+
+// strArgs returns the arguments of the command as strings. The arguments of a
+// FlatCmd are only approximated, since they aren't flattened.
+func (c *cmdAction) strArgs() []string {
+	if !c.flat {
+		return c.args
+	}
+	args := []string{c.flatKey[0]}
+	for _, arg := range c.flatArgs {
+		args = append(args, fmt.Sprint(arg))
+	}
+	return args
+}
+
 func (c *cmdAction) String() string {
 	return cmdString(c)
 }
@@ -355,6 +420,9 @@ func NewEvalScript(numKeys int, script string) EvalScript {
 var (
 	evalsha = []byte("EVALSHA")
 	eval    = []byte("EVAL")
+
+	evalPipelineCmds      = []string{"EVALSHA"}
+	evalPipelineCmdsNoSHA = []string{"EVAL"}
 )
 
 type evalAction struct {
@@ -362,6 +430,8 @@ type evalAction struct {
 	args []string
 	rcv  interface{}
 
+	// eval is set once the script was found to not be loaded, so that EVAL is
+	// used right away.
 	eval bool
 }
 
@@ -405,18 +475,45 @@ func (ec *evalAction) MarshalRESP(w io.Writer) error {
 	return err
 }
 
+func (ec *evalAction) UnmarshalRESP(br *bufio.Reader) error {
+	return resp2.Any{I: ec.rcv}.UnmarshalRESP(br)
+}
+
+// PipelineCmds implements the PipelineableAction interface. When pipelined the
+// script is performed using EVALSHA, see Pool.Do for how a NOSCRIPT error is
+// handled.
+func (ec *evalAction) PipelineCmds() []string {
+	if ec.eval {
+		return evalPipelineCmdsNoSHA
+	}
+	return evalPipelineCmds
+}
+
+// isPipelinedNoScript returns whether a is an EvalScript.Cmd Action which was
+// pipelined and failed with err because its script isn't loaded yet, in which
+// case it needs to be performed again on its own. If so a is changed to use
+// EVAL, which loads the script, right away.
+func isPipelinedNoScript(a Action, err error) bool {
+	ec, ok := unwrapSentinelRetryable(a).(*evalAction)
+	if !ok || err == nil || !strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		return false
+	}
+	ec.eval = true
+	return true
+}
+
 func (ec *evalAction) Run(conn Conn) error {
-	run := func(eval bool) error {
-		ec.eval = eval
+	run := func() error {
 		if err := conn.Encode(ec); err != nil {
 			return err
 		}
 		return conn.Decode(resp2.Any{I: ec.rcv})
 	}
 
-	err := run(false)
-	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
-		err = run(true)
+	err := run()
+	if err != nil && !ec.eval && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		ec.eval = true
+		err = run()
 	}
 	return err
 }
@@ -467,6 +564,44 @@ func (p pipeline) Run(c Conn) error {
 	return nil
 }
 
+// UnmarshalRESP implements the resp.Unmarshaler interface, so that the pipeline
+// can be pipelined as a whole with other commands (see PipelineableAction).
+//
+// Unlike Run, it reads the responses of all commands even if some of them are
+// errors, since the responses of the commands following the pipeline must
+// still be readable, and returns the first error. Only network errors cause it
+// to return right away.
+func (p pipeline) UnmarshalRESP(br *bufio.Reader) error {
+	var firstErr error
+	for _, cmd := range p {
+		err := cmd.UnmarshalRESP(br)
+		if _, ok := err.(net.Error); ok || err == io.EOF || err == io.ErrUnexpectedEOF {
+			return err
+		} else if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// PipelineCmds implements the PipelineableAction interface. A pipeline can only
+// be pipelined if all of its commands can be.
+func (p pipeline) PipelineCmds() []string {
+	var cmds []string
+	for _, cmd := range p {
+		pa, ok := cmd.(PipelineableAction)
+		if !ok {
+			return nil
+		}
+		paCmds := pa.PipelineCmds()
+		if paCmds == nil {
+			return nil
+		}
+		cmds = append(cmds, paCmds...)
+	}
+	return cmds
+}
+
 // MarshalRESP implements the resp.Marshaler interface, so that the pipeline can pass itself to the Conn.Encode method
 // instead of calling Conn.Encode for each CmdAction in the pipeline.
 //
@@ -177,6 +177,26 @@ func BenchmarkParallelGetSet(b *B) {
 				}
 			})
 		})
+
+		b.Run("mux", func(b *B) {
+			radix, err := NewMux("tcp", "127.0.0.1:6379")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer radix.Close()
+
+			do(b, func() {
+				if err := radix.Do(Cmd(nil, "SET", "foo", "bar")); err != nil {
+					b.Fatal(err)
+				}
+				var out string
+				if err := radix.Do(Cmd(&out, "GET", "foo")); err != nil {
+					b.Fatal(err)
+				} else if out != "bar" {
+					b.Fatal("got wrong value")
+				}
+			})
+		})
 	})
 
 	b.Run("redigo", func(b *B) {
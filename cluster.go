@@ -64,6 +64,12 @@ type ClusterOpt func(*clusterOpts)
 
 // ClusterPoolFunc tells the Cluster to use the given ClientFunc when creating
 // pools of connections to cluster members.
+//
+// If the ClientFunc returns *Pools with a circuit breaker (see
+// PoolCircuitBreaker), Actions on a member whose circuit is open fail right
+// away and cause the Cluster to re-synchronize its topology in the background.
+// Such members are also avoided when any member can be used, e.g. for the
+// synchronization itself.
 func ClusterPoolFunc(pf ClientFunc) ClusterOpt {
 	return func(co *clusterOpts) {
 		co.pf = pf
@@ -92,6 +98,10 @@ type Cluster struct {
 	pools          map[string]Client
 	primTopo, topo ClusterTopo
 
+	// syncCh is written to when a sync should be performed by the syncEvery
+	// routine right away, rather than on its next tick.
+	syncCh chan struct{}
+
 	closeCh   chan struct{}
 	closeWG   sync.WaitGroup
 	closeOnce sync.Once
@@ -116,6 +126,7 @@ func NewCluster(clusterAddrs []string, opts ...ClusterOpt) (*Cluster, error) {
 	c := &Cluster{
 		syncDedupe: newDedupe(),
 		pools:      map[string]Client{},
+		syncCh:     make(chan struct{}, 1),
 		closeCh:    make(chan struct{}),
 		ErrCh:      make(chan error, 1),
 	}
@@ -180,13 +191,28 @@ func assertKeysSlot(keys []string) error {
 	return nil
 }
 
+// circuitOpen returns whether cl is a *Pool whose circuit breaker is open, see
+// PoolCircuitBreaker.
+func circuitOpen(cl Client) bool {
+	p, ok := cl.(*Pool)
+	return ok && p.circuitErr() != nil
+}
+
 // may return nil, nil if no pool for the addr
 func (c *Cluster) rpool(addr string) (Client, error) {
 	c.l.RLock()
 	defer c.l.RUnlock()
 	if addr == "" {
+		// prefer pools which are known to be reachable
+		var openPool Client
 		for _, p := range c.pools {
-			return p, nil
+			if !circuitOpen(p) {
+				return p, nil
+			}
+			openPool = p
+		}
+		if openPool != nil {
+			return openPool, nil
 		}
 		return nil, errors.New("no pools available")
 	} else if p, ok := c.pools[addr]; ok {
@@ -327,6 +353,10 @@ func (c *Cluster) syncEvery(d time.Duration) {
 				if err := c.Sync(); err != nil {
 					c.err(err)
 				}
+			case <-c.syncCh:
+				if err := c.Sync(); err != nil {
+					c.err(err)
+				}
 			case <-c.closeCh:
 				return
 			}
@@ -334,6 +364,15 @@ func (c *Cluster) syncEvery(d time.Duration) {
 	}()
 }
 
+// triggerSync causes a sync to be performed in the background as soon as
+// possible.
+func (c *Cluster) triggerSync() {
+	select {
+	case c.syncCh <- struct{}{}:
+	default:
+	}
+}
+
 func (c *Cluster) addrForKey(key string) string {
 	s := ClusterSlot([]byte(key))
 	c.l.RLock()
@@ -414,6 +453,14 @@ func (c *Cluster) doInner(a Action, addr, key string, ask bool, attempts int) er
 		return nil
 	}
 
+	// the node is unreachable, which may be due to a failover, so the topology
+	// is re-checked in the background while the error is returned right away
+	var coErr *CircuitOpenError
+	if errors.As(err, &coErr) {
+		c.triggerSync()
+		return err
+	}
+
 	// if the error was a MOVED or ASK we can potentially retry
 	msg := err.Error()
 	moved := strings.HasPrefix(msg, "MOVED ")
@@ -0,0 +1,111 @@
+package radix
+
+type clusterStreamReaderRes struct {
+	i       int
+	stream  string
+	entries []StreamEntry
+	ok      bool
+}
+
+// clusterStreamReader implements the StreamReader interface for a Cluster by
+// wrapping one streamReader per slot.
+type clusterStreamReader struct {
+	readers []*streamReader
+
+	// resCh receives the result of every read started for one of the readers.
+	// It is buffered so that reads still in flight never block, even if Next
+	// isn't called anymore.
+	resCh    chan clusterStreamReaderRes
+	inFlight []bool
+
+	err error
+}
+
+// NewStreamReader returns a new StreamReader which reads from streams which
+// may be spread over multiple nodes of the cluster.
+//
+// Since Redis does not allow a single XREAD or XREADGROUP to read from keys in
+// different slots, even if they're served by the same node, the streams are
+// grouped by slot and one read is performed per slot. These reads are run
+// concurrently, and their results are returned by Next in the order in which
+// they arrive. Each read is performed through the Cluster, so MOVED and ASK
+// errors caused by slots migrating between nodes are followed just like for
+// any other Action.
+//
+// Each read uses a go-routine of its own, and, while it's in flight, a
+// connection of the node serving its slot. When using Block a node's Client
+// must therefore be able to provide a connection for every slot of that node
+// which streams are read from, and streams in the same slot should be
+// preferred where possible, e.g. by using hash tags.
+//
+// When using Block, Next returns as soon as any of the reads returns entries.
+// Reads that haven't returned yet are kept in flight and will be used by
+// successive calls to Next.
+//
+// Any changes on opts after calling NewStreamReader will have no effect.
+func (c *Cluster) NewStreamReader(opts StreamReaderOpts) StreamReader {
+	slots := map[uint16]map[string]*StreamEntryID{}
+	for stream, id := range opts.Streams {
+		slot := ClusterSlot([]byte(stream))
+		if slots[slot] == nil {
+			slots[slot] = map[string]*StreamEntryID{}
+		}
+		slots[slot][stream] = id
+	}
+
+	csr := &clusterStreamReader{
+		readers:  make([]*streamReader, 0, len(slots)),
+		resCh:    make(chan clusterStreamReaderRes, len(slots)),
+		inFlight: make([]bool, len(slots)),
+	}
+
+	for _, streams := range slots {
+		slotOpts := opts
+		slotOpts.Streams = streams
+		csr.readers = append(csr.readers, NewStreamReader(c, slotOpts).(*streamReader))
+	}
+
+	return csr
+}
+
+// Err implements the StreamReader interface.
+func (csr *clusterStreamReader) Err() error {
+	return csr.err
+}
+
+// Next implements the StreamReader interface.
+func (csr *clusterStreamReader) Next() (stream string, entries []StreamEntry, ok bool) {
+	if csr.err != nil {
+		return "", nil, false
+	}
+
+	for i, r := range csr.readers {
+		if csr.inFlight[i] {
+			continue
+		}
+
+		csr.inFlight[i] = true
+		go func(i int, r *streamReader) {
+			stream, entries, ok := r.Next()
+			csr.resCh <- clusterStreamReaderRes{i: i, stream: stream, entries: entries, ok: ok}
+		}(i, r)
+	}
+
+	// wait for the first read which returns entries. Reads which returned
+	// without any entries are restarted on the next call to Next, so if all
+	// reads come back empty Next returns without entries, like the
+	// non-cluster StreamReader.
+	for range csr.readers {
+		res := <-csr.resCh
+		csr.inFlight[res.i] = false
+
+		if !res.ok {
+			csr.err = csr.readers[res.i].Err()
+			return "", nil, false
+		} else if len(res.entries) > 0 {
+			return res.stream, res.entries, true
+		}
+	}
+
+	return "", nil, true
+}
@@ -0,0 +1,52 @@
+package radix
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterStreamReader(t *T) {
+	c, scl := newTestCluster()
+	defer c.Close()
+
+	// use streams from slots which are on different nodes
+	stream0, stream16k := clusterSlotKeys[0], clusterSlotKeys[16000]
+	require.NotEqual(t, scl.stubForSlot(0).addr, scl.stubForSlot(16000).addr)
+	require.Nil(t, c.Do(Cmd(nil, "SET", stream0, "foo")))
+	require.Nil(t, c.Do(Cmd(nil, "SET", stream16k, "bar")))
+
+	r := c.NewStreamReader(StreamReaderOpts{
+		Streams: map[string]*StreamEntryID{
+			stream0:   {},
+			stream16k: {},
+		},
+		NoBlock: true,
+	})
+
+	assertEntries := func(exp map[string]string) {
+		got := map[string]string{}
+		for len(got) < len(exp) {
+			stream, entries, ok := r.Next()
+			require.True(t, ok)
+			require.NoError(t, r.Err())
+			if len(entries) == 0 {
+				continue
+			}
+			require.Len(t, entries, 1)
+			assert.Equal(t, StreamEntryID{Time: 1}, entries[0].ID)
+			got[stream] = entries[0].Fields["value"]
+		}
+		assert.Equal(t, exp, got)
+	}
+
+	assertEntries(map[string]string{stream0: "foo", stream16k: "bar"})
+	assertNoStreamReaderEntries(t, r)
+
+	// migrate the slot of stream0, the next read should follow the MOVED to
+	// the new node and still work
+	scl.migrateSlotRange(scl.stubForSlot(16000).addr, 0, 1)
+	assertNoStreamReaderEntries(t, r)
+	assert.NoError(t, r.Err())
+}
@@ -122,6 +122,24 @@ func (s *clusterNodeStub) newConn() Conn {
 			return s.withKey(args[3], asking, func(slot clusterSlotStub) interface{} {
 				return "EVAL: success!"
 			})
+		case "XREAD":
+			// keys are treated as streams containing a single entry with the
+			// ID 1-0 and the key's value as field "value", if the key is set.
+			keys := findStreamsKeys(args[1:])
+			ids := args[len(args)-len(keys):]
+			return s.withKey(keys[0], asking, func(slot clusterSlotStub) interface{} {
+				var res []interface{}
+				for i, k := range keys {
+					if v, ok := slot.kv[k]; ok && ids[i] == "0-0" {
+						entry := []interface{}{"1-0", []string{"value", v}}
+						res = append(res, []interface{}{k, []interface{}{entry}})
+					}
+				}
+				if len(res) == 0 {
+					return nil
+				}
+				return res
+			})
 		case "PING":
 			return resp2.SimpleString{S: "PONG"}
 		case "CLUSTER":
@@ -229,11 +247,20 @@ func (scl *clusterStub) topo() ClusterTopo {
 	return tt
 }
 
+// clientFunc returns a ClientFunc which creates a Pool of stub connections for
+// each node, so that concurrent Actions don't share the state of a connection.
 func (scl *clusterStub) clientFunc() ClientFunc {
 	return func(network, addr string) (Client, error) {
 		for _, s := range scl.stubs {
 			if s.addr == addr {
-				return s.newConn(), nil
+				return NewPool(network, addr, 1,
+					PoolConnFunc(func(string, string) (Conn, error) {
+						return s.newConn(), nil
+					}),
+					PoolOnEmptyCreateAfter(0),
+					PoolPingInterval(0),
+					PoolPipelineWindow(0, 0),
+				)
 			}
 		}
 		return nil, fmt.Errorf("unknown addr: %q", addr)
@@ -0,0 +1,143 @@
+package radix
+
+import (
+	"strings"
+
+	"github.com/vikram-suki/radix/v3/resp"
+)
+
+// connState tracks the state of a Conn which may leak into later uses of the
+// Conn, as far as it can be inferred from the commands which are encoded on it.
+// Marshalers whose commands aren't known, e.g. custom Actions, are assumed not
+// to change the state.
+type connState struct {
+	// pending is the number of replies which are expected but weren't decoded
+	// yet.
+	pending int
+
+	// multi and watch are set by MULTI and WATCH, and can be reset using
+	// DISCARD and UNWATCH respectively.
+	multi, watch bool
+
+	// replyOff is set by CLIENT REPLY OFF and SKIP, which can't be reset
+	// reliably, since whether a reply is sent for CLIENT REPLY ON depends on
+	// which one was used.
+	replyOff bool
+
+	// unresettable is set by commands whose effect can't be reset without
+	// knowing how the Conn was set up, e.g. SELECT, since the ConnFunc may
+	// have selected a different DB than the default. RESET isn't used for the
+	// same reason, it also undoes any AUTH.
+	unresettable bool
+}
+
+func (s *connState) trackEncode(m resp.Marshaler) {
+	switch m := m.(type) {
+	case pipeline:
+		// the response of each command in a pipeline is decoded separately,
+		// while a pipeline within a pipeline is decoded at once
+		for _, cmd := range m {
+			s.pending++
+			s.trackCmds(cmd)
+		}
+	case pipelinerPipeline:
+		s.trackEncode(m.pipeline)
+	default:
+		s.pending++
+		s.trackCmds(m)
+	}
+}
+
+// trackCmds tracks the commands performed by m, without tracking its response.
+func (s *connState) trackCmds(m resp.Marshaler) {
+	switch m := m.(type) {
+	case *cmdAction:
+		s.trackCmd(m.cmd, m)
+	case *pipelinerCmd:
+		s.trackCmds(m.CmdAction)
+	case pipeline:
+		for _, cmd := range m {
+			s.trackCmds(cmd)
+		}
+	case sentinelRetryableCmdAction:
+		s.trackCmds(m.CmdAction)
+	case PipelineableAction:
+		// only the names of the commands are known
+		for _, cmd := range m.PipelineCmds() {
+			s.trackCmd(cmd, nil)
+		}
+	}
+}
+
+func (s *connState) trackDecode() {
+	if s.pending > 0 {
+		s.pending--
+	}
+}
+
+// connStateCmds contains the commands which affect the connState, and how they
+// are tracked. The *cmdAction is nil if only the name of the command is known.
+var connStateCmds = map[string]func(*connState, *cmdAction){
+	"MULTI":   (*connState).trackMulti,
+	"EXEC":    (*connState).trackExec,
+	"DISCARD": (*connState).trackExec,
+	"WATCH":   (*connState).trackWatch,
+	"UNWATCH": (*connState).trackUnwatch,
+	"CLIENT":  (*connState).trackClient,
+
+	"SELECT":     (*connState).trackUnresettable,
+	"RESET":      (*connState).trackUnresettable,
+	"HELLO":      (*connState).trackUnresettable,
+	"SUBSCRIBE":  (*connState).trackUnresettable,
+	"PSUBSCRIBE": (*connState).trackUnresettable,
+	"SSUBSCRIBE": (*connState).trackUnresettable,
+	"MONITOR":    (*connState).trackUnresettable,
+}
+
+func (s *connState) trackCmd(cmd string, c *cmdAction) {
+	if track, ok := connStateCmds[strings.ToUpper(cmd)]; ok {
+		track(s, c)
+	}
+}
+
+func (s *connState) trackMulti(*cmdAction)        { s.multi = true }
+func (s *connState) trackExec(*cmdAction)         { s.multi, s.watch = false, false }
+func (s *connState) trackWatch(*cmdAction)        { s.watch = true }
+func (s *connState) trackUnwatch(*cmdAction)      { s.watch = false }
+func (s *connState) trackUnresettable(*cmdAction) { s.unresettable = true }
+
+// trackClient tracks CLIENT REPLY OFF|ON|SKIP. If the arguments aren't known
+// the Conn is treated as if replies were turned off.
+func (s *connState) trackClient(c *cmdAction) {
+	if c == nil {
+		s.replyOff = true
+		return
+	}
+	args := c.strArgs()
+	if len(args) > 1 && strings.EqualFold(args[0], "REPLY") {
+		s.replyOff = s.replyOff || !strings.EqualFold(args[1], "ON")
+	}
+}
+
+// clean returns whether the Conn's state doesn't need to be reset.
+func (s *connState) clean() bool {
+	return !s.unresettable && !s.replyOff && s.pending == 0 &&
+		!s.multi && !s.watch
+}
+
+// reset tries to reset the Conn's state, using conn to perform commands on it,
+// and returns whether the Conn can be reused afterwards.
+func (s *connState) reset(conn Conn) bool {
+	if s.unresettable || s.replyOff || s.pending != 0 {
+		return false
+	}
+
+	// DISCARD also unwatches all keys
+	var err error
+	if s.multi {
+		err = conn.Do(Cmd(nil, "DISCARD"))
+	} else if s.watch {
+		err = conn.Do(Cmd(nil, "UNWATCH"))
+	}
+	return err == nil && !s.multi && !s.watch && s.pending == 0
+}
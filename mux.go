@@ -0,0 +1,469 @@
+package radix
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// muxStatefulCmds are commands which change the state of the connection they're
+// performed on, and so can't be performed on a connection shared by a Mux.
+var muxStatefulCmds = map[string]bool{
+	"AUTH":      true,
+	"SELECT":    true,
+	"HELLO":     true,
+	"RESET":     true,
+	"QUIT":      true,
+	"CLIENT":    true,
+	"READONLY":  true,
+	"READWRITE": true,
+	"ASKING":    true,
+
+	"MULTI":   true,
+	"EXEC":    true,
+	"DISCARD": true,
+	"WATCH":   true,
+	"UNWATCH": true,
+
+	"SUBSCRIBE":    true,
+	"PSUBSCRIBE":   true,
+	"SSUBSCRIBE":   true,
+	"UNSUBSCRIBE":  true,
+	"PUNSUBSCRIBE": true,
+	"SUNSUBSCRIBE": true,
+	"MONITOR":      true,
+}
+
+const (
+	// muxMaxBatch is the maximum number of requests which are written to a
+	// connection using a single Encode call.
+	muxMaxBatch = 128
+
+	// muxMaxInFlight is the maximum number of requests which were written to a
+	// connection but whose responses weren't read yet.
+	muxMaxInFlight = 1024
+
+	// muxReconnectInterval is how long a Mux waits before trying to recreate
+	// a connection after that failed.
+	muxReconnectInterval = 100 * time.Millisecond
+)
+
+type muxOpts struct {
+	cf       ConnFunc
+	numConns int
+	pf       ClientFunc
+}
+
+// MuxOpt is an optional behavior which can be applied to the NewMux function to
+// effect a Mux's behavior
+type MuxOpt func(*muxOpts)
+
+// MuxConnFunc tells the Mux to use the given ConnFunc when creating the
+// connections it multiplexes Actions over. The ConnFunc can be used to set
+// timeouts, perform AUTH, or even use custom Conn implementations.
+func MuxConnFunc(cf ConnFunc) MuxOpt {
+	return func(mo *muxOpts) {
+		mo.cf = cf
+	}
+}
+
+// MuxNumConns tells the Mux how many connections to multiplex Actions over.
+// Actions are distributed over the connections in a round-robin fashion.
+//
+// If n is less than 1 then 1 is used.
+func MuxNumConns(n int) MuxOpt {
+	return func(mo *muxOpts) {
+		mo.numConns = n
+	}
+}
+
+// MuxPoolFunc tells the Mux to use the given ClientFunc to create the Client
+// which is used for Actions which can't be multiplexed, e.g. blocking commands
+// or Actions created using WithConn. The Client is closed when the Mux is
+// closed.
+func MuxPoolFunc(pf ClientFunc) MuxOpt {
+	return func(mo *muxOpts) {
+		mo.pf = pf
+	}
+}
+
+// Mux is a Client which multiplexes the Actions performed on it from any
+// number of go-routines over a single connection, or a few of them (see
+// MuxNumConns), rather than giving each Action exclusive use of a connection
+// like Pool does. For parallel workloads this can significantly reduce the
+// number of connections and syscalls needed.
+//
+// Each connection has a single writer go-routine, which writes all commands
+// which are queued on it at once, and a single reader go-routine, which reads
+// their responses in the order they were written. If a connection fails, all
+// Actions waiting for a response on it fail with the error, and a new
+// connection is created in the background.
+//
+// Only PipelineableActions, e.g. those created using Cmd, FlatCmd or
+// EvalScript.Cmd, are multiplexed, except for blocking commands like BLPOP and
+// commands which change the state of the connection, like SELECT or MULTI.
+// All other Actions, including those created using WithConn, are performed on
+// a separate Client, see MuxPoolFunc.
+type Mux struct {
+	// next is used to pick the connection for the next Action, and is only
+	// accessed atomically. It's at the start of the struct to guarantee its
+	// alignment.
+	next uint64
+
+	opts          muxOpts
+	network, addr string
+
+	conns []*muxConn
+	pool  Client
+
+	// l protects closed, and is read locked while requests are being queued
+	l      sync.RWMutex
+	closed bool
+
+	closeCh chan struct{}
+	closeWG sync.WaitGroup
+
+	// Any errors encountered internally will be written to this channel. If
+	// nothing is reading the channel the errors will be dropped.
+	ErrCh chan error
+}
+
+var _ Client = (*Mux)(nil)
+
+// NewMux creates a *Mux which multiplexes Actions over connections to the
+// redis instance at the given address.
+//
+// NewMux takes in a number of options which can overwrite its default
+// behavior. The default options NewMux uses are:
+//
+//	MuxConnFunc(DefaultConnFunc)
+//	MuxNumConns(1)
+//	MuxPoolFunc(func(network, addr string) (Client, error) {
+//		// cf is the ConnFunc given by MuxConnFunc
+//		return NewPool(network, addr, 4, PoolConnFunc(cf), PoolPipelineWindow(0, 0))
+//	})
+//
+func NewMux(network, addr string, opts ...MuxOpt) (*Mux, error) {
+	m := &Mux{
+		network: network,
+		addr:    addr,
+		closeCh: make(chan struct{}),
+		ErrCh:   make(chan error, 1),
+	}
+
+	defaultMuxOpts := []MuxOpt{
+		MuxConnFunc(DefaultConnFunc),
+		MuxNumConns(1),
+	}
+
+	for _, opt := range append(defaultMuxOpts, opts...) {
+		if opt != nil {
+			opt(&(m.opts))
+		}
+	}
+
+	if m.opts.numConns < 1 {
+		m.opts.numConns = 1
+	}
+	if m.opts.pf == nil {
+		cf := m.opts.cf
+		m.opts.pf = func(network, addr string) (Client, error) {
+			return NewPool(network, addr, 4, PoolConnFunc(cf), PoolPipelineWindow(0, 0))
+		}
+	}
+
+	// all connections are created synchronously, to ensure there's actually a
+	// redis instance present
+	conns := make([]Conn, 0, m.opts.numConns)
+	closeConns := func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	for i := 0; i < m.opts.numConns; i++ {
+		conn, err := m.opts.cf(network, addr)
+		if err != nil {
+			closeConns()
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+
+	var err error
+	if m.pool, err = m.opts.pf(network, addr); err != nil {
+		closeConns()
+		return nil, err
+	}
+
+	m.conns = make([]*muxConn, len(conns))
+	for i, conn := range conns {
+		mc := &muxConn{m: m, reqCh: make(chan *muxReq, muxMaxBatch)}
+		m.conns[i] = mc
+		m.closeWG.Add(1)
+		go mc.spin(conn)
+	}
+	return m, nil
+}
+
+func (m *Mux) err(err error) {
+	select {
+	case m.ErrCh <- err:
+	default:
+	}
+}
+
+// Do implements the Do method of the Client interface. If the Action can be
+// multiplexed it is queued on one of the Mux's connections, and Do returns
+// once its response was read. Otherwise it is performed on the Client created
+// by MuxPoolFunc.
+//
+// A multiplexed EvalScript.Cmd Action whose script isn't loaded on the server
+// yet is performed again on that Client, which loads the script.
+func (m *Mux) Do(a Action) error {
+	if !canPipeline(a, blockingCmds, muxStatefulCmds) {
+		return m.pool.Do(a)
+	}
+
+	mc := m.conns[atomic.AddUint64(&m.next, 1)%uint64(len(m.conns))]
+	if err := mc.do(a.(CmdAction)); !isPipelinedNoScript(a, err) {
+		return err
+	}
+	return m.pool.Do(a)
+}
+
+// Close implements the Close method of the Client interface. Actions which are
+// waiting for their response when Close is called are completed first.
+func (m *Mux) Close() error {
+	m.l.Lock()
+	if m.closed {
+		m.l.Unlock()
+		return errClientClosed
+	}
+	m.closed = true
+	m.l.Unlock()
+
+	close(m.closeCh)
+	m.closeWG.Wait()
+
+	// no more requests can be queued now, but some might have been queued
+	// after the connections stopped handling them
+	for _, mc := range m.conns {
+		mc.fail(errClientClosed)
+	}
+	return m.pool.Close()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type muxReq struct {
+	CmdAction
+	resCh chan error
+}
+
+var muxReqPool sync.Pool
+
+func getMuxReq(cmd CmdAction) *muxReq {
+	req, _ := muxReqPool.Get().(*muxReq)
+	if req != nil {
+		req.CmdAction = cmd
+		return req
+	}
+	return &muxReq{
+		CmdAction: cmd,
+		resCh:     make(chan error, 1),
+	}
+}
+
+func putMuxReq(req *muxReq) {
+	req.CmdAction = nil
+	muxReqPool.Put(req)
+}
+
+// muxBatch is written to a connection using a single Encode call, like a
+// pipeline.
+type muxBatch []*muxReq
+
+func (mb muxBatch) MarshalRESP(w io.Writer) error {
+	for _, req := range mb {
+		if err := req.MarshalRESP(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// muxConn handles the requests queued on one connection of a Mux, and
+// recreates the connection if it fails.
+type muxConn struct {
+	m     *Mux
+	reqCh chan *muxReq
+}
+
+func (mc *muxConn) do(cmd CmdAction) error {
+	req := getMuxReq(cmd)
+
+	mc.m.l.RLock()
+	if mc.m.closed {
+		mc.m.l.RUnlock()
+		putMuxReq(req)
+		return errClientClosed
+	}
+	mc.reqCh <- req
+	mc.m.l.RUnlock()
+
+	err := <-req.resCh
+	putMuxReq(req)
+	return err
+}
+
+// fail fails all requests which are currently queued with err.
+func (mc *muxConn) fail(err error) {
+	for {
+		select {
+		case req := <-mc.reqCh:
+			req.resCh <- err
+		default:
+			return
+		}
+	}
+}
+
+// failFor fails all requests which are queued within d with err, and returns
+// false if the Mux was closed in the meantime.
+func (mc *muxConn) failFor(err error, d time.Duration) bool {
+	t := getTimer(d)
+	defer putTimer(t)
+	for {
+		select {
+		case req := <-mc.reqCh:
+			req.resCh <- err
+		case <-t.C:
+			return true
+		case <-mc.m.closeCh:
+			return false
+		}
+	}
+}
+
+func (mc *muxConn) spin(conn Conn) {
+	defer mc.m.closeWG.Done()
+	for {
+		err := mc.run(conn)
+		conn.Close()
+		if err != nil {
+			mc.m.err(err)
+		}
+
+		select {
+		case <-mc.m.closeCh:
+			return
+		default:
+		}
+
+		// requests aren't queued while there's no connection, they fail
+		// right away instead
+		for {
+			if conn, err = mc.m.opts.cf(mc.m.network, mc.m.addr); err == nil {
+				break
+			}
+			mc.m.err(err)
+			if !mc.failFor(err, muxReconnectInterval) {
+				return
+			}
+		}
+	}
+}
+
+// isMuxConnErr returns whether err means that conn can't be used anymore.
+func isMuxConnErr(err error) bool {
+	_, ok := err.(net.Error)
+	return ok || err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+// run handles requests using conn until either the connection fails, in which
+// case the error is returned, or the Mux is closed.
+func (mc *muxConn) run(conn Conn) error {
+	decodeCh := make(chan *muxReq, muxMaxInFlight)
+	brokenCh := make(chan struct{})
+	readDoneCh := make(chan struct{})
+
+	// readErr is only written by the reader, and only read after it is done
+	var readErr error
+	go func() {
+		defer close(readDoneCh)
+		for req := range decodeCh {
+			if readErr != nil {
+				req.resCh <- readErr
+				continue
+			}
+
+			err := conn.Decode(req)
+			if isMuxConnErr(err) {
+				readErr = err
+				close(brokenCh)
+			}
+			req.resCh <- err
+		}
+	}()
+
+	err := mc.writeLoop(conn, decodeCh, brokenCh)
+	if err != nil {
+		// makes the reader fail all requests which are waiting for their
+		// response
+		conn.Close()
+	}
+	close(decodeCh)
+	<-readDoneCh
+
+	if err == nil {
+		err = readErr
+	}
+	return err
+}
+
+// writeLoop writes all queued requests to conn, passing them on to the reader
+// once written, until either writing fails, the reader failed, or the Mux is
+// closed.
+func (mc *muxConn) writeLoop(conn Conn, decodeCh chan<- *muxReq, brokenCh <-chan struct{}) error {
+	batch := make(muxBatch, 0, muxMaxBatch)
+	for {
+		select {
+		case req := <-mc.reqCh:
+			batch = append(batch, req)
+		case <-brokenCh:
+			return nil
+		case <-mc.m.closeCh:
+			return nil
+		}
+
+	batchLoop:
+		for len(batch) < muxMaxBatch {
+			select {
+			case req := <-mc.reqCh:
+				batch = append(batch, req)
+			default:
+				break batchLoop
+			}
+		}
+
+		// the requests are only passed to the reader once they were written,
+		// as they may be reused as soon as the reader completed them, and
+		// fail right away if writing fails
+		err := conn.Encode(batch)
+		for i, req := range batch {
+			if err != nil {
+				req.resCh <- err
+			} else {
+				decodeCh <- req
+			}
+			batch[i] = nil
+		}
+		batch = batch[:0]
+
+		if err != nil {
+			return err
+		}
+	}
+}
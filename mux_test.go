@@ -0,0 +1,186 @@
+package radix
+
+import (
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vikram-suki/radix/v3/resp"
+)
+
+// countingClient counts the Actions performed on the wrapped Client.
+type countingClient struct {
+	Client
+	n *int64
+}
+
+func (cc countingClient) Do(a Action) error {
+	atomic.AddInt64(cc.n, 1)
+	return cc.Client.Do(a)
+}
+
+type muxTest struct {
+	mux *Mux
+
+	l     sync.Mutex
+	conns []Conn
+
+	// poolDos is the number of Actions performed on the Mux's pool
+	poolDos int64
+}
+
+func newMuxTest(t *T, opts ...MuxOpt) *muxTest {
+	mt := new(muxTest)
+	connFunc := func(network, addr string) (Conn, error) {
+		conn := Stub(network, addr, func(args []string) interface{} {
+			return args[len(args)-1]
+		})
+		mt.l.Lock()
+		defer mt.l.Unlock()
+		mt.conns = append(mt.conns, conn)
+		return conn, nil
+	}
+	poolFunc := func(network, addr string) (Client, error) {
+		pool, err := NewPool(network, addr, 1, PoolConnFunc(connFunc))
+		return countingClient{Client: pool, n: &mt.poolDos}, err
+	}
+
+	var err error
+	opts = append([]MuxOpt{MuxConnFunc(connFunc), MuxPoolFunc(poolFunc)}, opts...)
+	mt.mux, err = NewMux("tcp", "127.0.0.1:6379", opts...)
+	require.NoError(t, err)
+	return mt
+}
+
+func TestMux(t *T) {
+	mt := newMuxTest(t, MuxNumConns(2))
+	defer mt.mux.Close()
+
+	// actions from many go-routines are multiplexed, and each gets its own
+	// response
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				exp := strconv.Itoa(i) + "-" + strconv.Itoa(j)
+				var out string
+				assert.NoError(t, mt.mux.Do(Cmd(&out, "ECHO", exp)))
+				assert.Equal(t, exp, out)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var pipeA, pipeB, eval string
+	script := NewEvalScript(1, "return KEYS[1]")
+	assert.NoError(t, mt.mux.Do(Pipeline(Cmd(&pipeA, "ECHO", "a"), FlatCmd(&pipeB, "ECHO", "b"))))
+	assert.NoError(t, mt.mux.Do(script.Cmd(&eval, "eval")))
+	assert.Equal(t, "a", pipeA)
+	assert.Equal(t, "b", pipeB)
+	assert.Equal(t, "eval", eval)
+	assert.Zero(t, atomic.LoadInt64(&mt.poolDos))
+
+	// the mux's connections and one connection of the pool
+	mt.l.Lock()
+	assert.Len(t, mt.conns, 3)
+	mt.l.Unlock()
+}
+
+func TestMuxPool(t *T) {
+	mt := newMuxTest(t)
+	defer mt.mux.Close()
+
+	for _, a := range []Action{
+		Cmd(nil, "BLPOP", "foo", "0"),
+		Cmd(nil, "MULTI"),
+		FlatCmd(nil, "SELECT", "1"),
+		Pipeline(Cmd(nil, "ECHO", "foo"), Cmd(nil, "WATCH", "foo")),
+		WithConn("foo", func(conn Conn) error {
+			return conn.Do(Cmd(nil, "ECHO", "foo"))
+		}),
+	} {
+		before := atomic.LoadInt64(&mt.poolDos)
+		assert.NoError(t, mt.mux.Do(a))
+		assert.Equal(t, before+1, atomic.LoadInt64(&mt.poolDos), "action:%#v", a)
+	}
+}
+
+func TestMuxReconnect(t *T) {
+	mt := newMuxTest(t)
+	defer mt.mux.Close()
+	require.NoError(t, mt.mux.Do(Cmd(nil, "ECHO", "foo")))
+
+	// the first connection is the one being multiplexed over
+	mt.l.Lock()
+	mt.conns[0].Close()
+	mt.l.Unlock()
+
+	assert.Eventually(t, func() bool {
+		var out string
+		return mt.mux.Do(Cmd(&out, "ECHO", "bar")) == nil && out == "bar"
+	}, time.Second, time.Millisecond)
+
+	select {
+	case err := <-mt.mux.ErrCh:
+		assert.Error(t, err)
+	default:
+		t.Fatal("no error was written to ErrCh")
+	}
+
+	mt.l.Lock()
+	assert.Len(t, mt.conns, 3)
+	mt.l.Unlock()
+}
+
+func TestMuxClose(t *T) {
+	mt := newMuxTest(t)
+	require.NoError(t, mt.mux.Do(Cmd(nil, "ECHO", "foo")))
+	require.NoError(t, mt.mux.Close())
+
+	assert.Equal(t, errClientClosed, mt.mux.Do(Cmd(nil, "ECHO", "foo")))
+	assert.Equal(t, errClientClosed, mt.mux.Close())
+}
+
+// brokenConn is a Conn whose Encode is slow and whose Decode always fails, as
+// if the connection was already closed by the server.
+type brokenConn struct {
+	Conn
+}
+
+func (bc brokenConn) Encode(m resp.Marshaler) error {
+	time.Sleep(2 * time.Millisecond)
+	return m.MarshalRESP(ioutil.Discard)
+}
+
+func (bc brokenConn) Decode(resp.Unmarshaler) error {
+	return io.EOF
+}
+
+func TestMuxBrokenConn(t *T) {
+	connFunc := func(network, addr string) (Conn, error) {
+		return brokenConn{Conn: Stub(network, addr, nil)}, nil
+	}
+	mux, err := NewMux("tcp", "127.0.0.1:6379", MuxConnFunc(connFunc))
+	require.NoError(t, err)
+	defer mux.Close()
+
+	// requests must not be reused while they're still being written, even if
+	// reading their response failed already
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Error(t, mux.Do(Cmd(nil, "GET", "a")))
+		}()
+	}
+	wg.Wait()
+}
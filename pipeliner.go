@@ -1,8 +1,10 @@
 package radix
 
 import (
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,12 +25,110 @@ var blockingCmds = map[string]bool{
 	"SAVE":  true,
 }
 
+// blockingTimeout returns whether a is a LongRunningAction or performs any of
+// the blockingCmds, and if so the longest time it may block for, or 0 if that's
+// unknown or unlimited.
+func blockingTimeout(a Action) (time.Duration, bool) {
+	switch a := a.(type) {
+	case LongRunningAction:
+		return a.LongRunning(), true
+	case *cmdAction:
+		if !blockingCmds[strings.ToUpper(a.cmd)] {
+			return 0, false
+		}
+		return cmdBlockingTimeout(strings.ToUpper(a.cmd), a.strArgs())
+	case ctxCmdAction:
+		return blockingTimeout(a.CmdAction)
+	case sentinelRetryableAction, sentinelRetryableCmdAction:
+		return blockingTimeout(unwrapSentinelRetryable(a))
+	case pipeline:
+		var total time.Duration
+		var unlimited, blocking bool
+		for _, cmd := range a {
+			if d, ok := blockingTimeout(cmd); ok {
+				blocking = true
+				unlimited = unlimited || d == 0
+				total += d
+			}
+		}
+		if unlimited {
+			total = 0
+		}
+		return total, blocking
+	case PipelineableAction:
+		for _, cmd := range a.PipelineCmds() {
+			if blockingCmds[strings.ToUpper(cmd)] {
+				return 0, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// cmdBlockingTimeout returns the timeout given to the blocking command cmd, or
+// 0 if it's unlimited or can't be parsed. XREAD and XREADGROUP are only
+// blocking if they're given the BLOCK option.
+func cmdBlockingTimeout(cmd string, args []string) (time.Duration, bool) {
+	var timeout string
+	var unit time.Duration
+	switch cmd {
+	case "BLPOP", "BRPOP", "BRPOPLPUSH", "BZPOPMIN", "BZPOPMAX":
+		if len(args) > 0 {
+			timeout, unit = args[len(args)-1], time.Second
+		}
+	case "WAIT":
+		if len(args) > 0 {
+			timeout, unit = args[len(args)-1], time.Millisecond
+		}
+	case "XREAD", "XREADGROUP":
+		// the keys and IDs following STREAMS might be called BLOCK as well
+		var block bool
+		for i, arg := range args {
+			if strings.EqualFold(arg, "STREAMS") {
+				break
+			} else if strings.EqualFold(arg, "BLOCK") && i+1 < len(args) {
+				block, timeout, unit = true, args[i+1], time.Millisecond
+				break
+			}
+		}
+		if !block {
+			return 0, false
+		}
+	}
+
+	f, err := strconv.ParseFloat(timeout, 64)
+	if err != nil || f <= 0 {
+		return 0, true
+	}
+	return time.Duration(f * float64(unit)), true
+}
+
+// pipelinerMaxCmds is the maximum number of commands a single Action may
+// perform to be pipelined, so that large Pipelines don't delay the Actions
+// they're pipelined with.
+const pipelinerMaxCmds = 16
+
+// pipelinerAdaptiveStep is how much the window of an adaptive pipeliner grows
+// for each Do call which may still join the pipeline.
+const pipelinerAdaptiveStep = 10 * time.Microsecond
+
 type pipeliner struct {
+	// active is the number of Do calls in progress, and inFlight the number of
+	// requests which are part of pipelines being performed. Both are only used
+	// if adaptive is set, and only accessed atomically. They're at the start
+	// of the struct to guarantee their alignment.
+	active, inFlight int64
+
 	c Client
 
 	limit  int
 	window time.Duration
 
+	// adaptive is set if the window is only the maximum window, and the actual
+	// one depends on the number of concurrent Do calls, see
+	// PoolPipelineAdaptiveWindow.
+	adaptive bool
+
 	// reqsBufCh contains buffers for collecting commands and acts as a semaphore
 	// to limit the number of concurrent flushes.
 	reqsBufCh chan []CmdAction
@@ -36,13 +136,17 @@ type pipeliner struct {
 	reqCh chan *pipelinerCmd
 	reqWG sync.WaitGroup
 
+	// onFlush, if set, is called after each pipeline was performed with the
+	// number of commands in it and the error it failed with, if any.
+	onFlush func(numCmds int, err error)
+
 	l      sync.RWMutex
 	closed bool
 }
 
 var _ Client = (*pipeliner)(nil)
 
-func newPipeliner(c Client, concurrency, limit int, window time.Duration) *pipeliner {
+func newPipeliner(c Client, concurrency, limit int, window time.Duration, adaptive bool) *pipeliner {
 	if concurrency < 1 {
 		concurrency = 1
 	}
@@ -50,8 +154,9 @@ func newPipeliner(c Client, concurrency, limit int, window time.Duration) *pipel
 	p := &pipeliner{
 		c: c,
 
-		limit:  limit,
-		window: window,
+		limit:    limit,
+		window:   window,
+		adaptive: adaptive,
 
 		reqsBufCh: make(chan []CmdAction, concurrency),
 
@@ -79,13 +184,40 @@ func newPipeliner(c Client, concurrency, limit int, window time.Duration) *pipel
 //
 // If CanDo returns false, the Action must not be given to Do.
 func (p *pipeliner) CanDo(a Action) bool {
-	// there is currently no way to get the command for CmdAction implementations
-	// from outside the radix package so we can not multiplex those commands. User
-	// defined pipelines are not pipelined to let the user better control them.
-	if cmdA, ok := a.(*cmdAction); ok {
-		return !blockingCmds[strings.ToUpper(cmdA.cmd)]
+	return canPipeline(a, blockingCmds)
+}
+
+// canPipeline returns whether a can be pipelined with other Actions, and none
+// of its commands are in any of the excluded sets of commands.
+func canPipeline(a Action, excluded ...map[string]bool) bool {
+	isExcluded := func(cmd string) bool {
+		cmd = strings.ToUpper(cmd)
+		for _, cmds := range excluded {
+			if cmds[cmd] {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch a := a.(type) {
+	case *cmdAction:
+		// this is the common case, which doesn't need to allocate
+		return !isExcluded(a.cmd)
+	case PipelineableAction:
+		cmds := a.PipelineCmds()
+		if len(cmds) == 0 || len(cmds) > pipelinerMaxCmds {
+			return false
+		}
+		for _, cmd := range cmds {
+			if isExcluded(cmd) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
 	}
-	return false
 }
 
 // Do executes the given Action as part of the pipeline.
@@ -94,15 +226,28 @@ func (p *pipeliner) CanDo(a Action) bool {
 func (p *pipeliner) Do(a Action) error {
 	req := getPipelinerCmd(a.(CmdAction)) // get this outside the lock to avoid
 
+	if p.adaptive {
+		atomic.AddInt64(&p.active, 1)
+	}
+
 	p.l.RLock()
 	if p.closed {
 		p.l.RUnlock()
+		if p.adaptive {
+			atomic.AddInt64(&p.active, -1)
+		}
 		return errClientClosed
 	}
 	p.reqCh <- req
 	p.l.RUnlock()
 
 	err := <-req.resCh
+	if p.adaptive {
+		// active is decremented first, so that this request is never counted
+		// as one which may still join a pipeline
+		atomic.AddInt64(&p.active, -1)
+		atomic.AddInt64(&p.inFlight, -1)
+	}
 	poolPipelinerCmd(req)
 	return err
 }
@@ -155,9 +300,19 @@ func (p *pipeliner) reqLoop() {
 				// if we reached the pipeline limit, execute now to avoid unnecessary waiting
 				t.Stop()
 
+				reqs = p.flush(reqs)
+			} else if !p.adaptive {
+				if len(reqs) == 1 {
+					t.Reset(p.window)
+				}
+			} else if window := p.adaptiveWindow(len(reqs)); window == 0 {
+				// no other request can join the pipeline, so waiting would
+				// only add latency
+				t.Stop()
+
 				reqs = p.flush(reqs)
 			} else if len(reqs) == 1 {
-				t.Reset(p.window)
+				t.Reset(window)
 			}
 		case <-t.C:
 			reqs = p.flush(reqs)
@@ -165,11 +320,33 @@ func (p *pipeliner) reqLoop() {
 	}
 }
 
+// adaptiveWindow returns how long to wait for more requests to join a pipeline
+// of numReqs requests, which grows with the number of Do calls which may still
+// join it, or 0 if there are none.
+func (p *pipeliner) adaptiveWindow(numReqs int) time.Duration {
+	others := atomic.LoadInt64(&p.active) - atomic.LoadInt64(&p.inFlight) - int64(numReqs)
+	if others <= 0 && len(p.reqCh) == 0 {
+		return 0
+	}
+
+	window := time.Duration(others) * pipelinerAdaptiveStep
+	if window < pipelinerAdaptiveStep {
+		window = pipelinerAdaptiveStep
+	} else if window > p.window {
+		window = p.window
+	}
+	return window
+}
+
 func (p *pipeliner) flush(reqs []CmdAction) []CmdAction {
 	if len(reqs) == 0 {
 		return reqs
 	}
 
+	if p.adaptive {
+		atomic.AddInt64(&p.inFlight, int64(len(reqs)))
+	}
+
 	go func() {
 		defer func() {
 			p.reqsBufCh <- reqs[:0]
@@ -179,11 +356,15 @@ func (p *pipeliner) flush(reqs []CmdAction) []CmdAction {
 			pipeline: pipeline(reqs),
 		}
 
-		if err := p.c.Do(pipe); err != nil {
+		err := p.c.Do(pipe)
+		if err != nil {
 			for _, req := range reqs {
 				req.(*pipelinerCmd).resCh <- err
 			}
 		}
+		if p.onFlush != nil {
+			p.onFlush(len(reqs), err)
+		}
 	}()
 
 	return <-p.reqsBufCh
@@ -1,21 +1,81 @@
 package radix
 
 import (
+	"container/list"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/vikram-suki/radix/v3/resp"
+	"github.com/vikram-suki/radix/v3/trace"
 )
 
 // ErrPoolEmpty is used by Pools created using the PoolOnEmptyErrAfter option
 var ErrPoolEmpty = errors.New("connection pool is empty")
 
+// ErrPoolQueueFull is used by Pools created using the PoolFIFOWait option, when
+// the maximum number of Actions are waiting for a connection already.
+var ErrPoolQueueFull = errors.New("connection pool wait queue is full")
+
 var errPoolFull = errors.New("connection pool is full")
 
-// TODO do something with errors which happen asynchronously
+// CircuitOpenError is returned by a Pool whose circuit breaker is open, see
+// PoolCircuitBreaker, instead of attempting to perform an Action.
+type CircuitOpenError struct {
+	// Network and Addr are the arguments the Pool was created with.
+	Network, Addr string
+
+	// Err is the last error which caused the circuit to open.
+	Err error
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for %s %s: %s", e.Network, e.Addr, e.Err)
+}
+
+// Unwrap returns the error which caused the circuit to open.
+func (e *CircuitOpenError) Unwrap() error {
+	return e.Err
+}
+
+// PoolDeadlineAction is an Action which limits how long a Pool may wait for a
+// connection to perform it on, e.g. to give each request its own deadline
+// when using PoolFIFOWait.
+type PoolDeadlineAction interface {
+	Action
+
+	// PoolDeadline returns the time until which the Pool may wait for a
+	// connection if none is available, which overrides the PoolOnEmpty
+	// options. If no connection became available by then ErrPoolEmpty is
+	// returned. If the zero time is returned the options are used.
+	//
+	// The deadline isn't used if the Action is pipelined automatically (see
+	// PoolPipelineWindow).
+	PoolDeadline() time.Time
+}
+
+// poolDeadline returns the deadline of a if it's a PoolDeadlineAction, which
+// may be wrapped by this package.
+func poolDeadline(a Action) time.Time {
+	switch a := a.(type) {
+	case PoolDeadlineAction:
+		return a.PoolDeadline()
+	case blockingAction:
+		return poolDeadline(a.Action)
+	case longRunningAction:
+		return poolDeadline(a.Action)
+	case ctxCmdAction:
+		return poolDeadline(a.CmdAction)
+	case sentinelRetryableAction, sentinelRetryableCmdAction:
+		return poolDeadline(unwrapSentinelRetryable(a))
+	default:
+		return time.Time{}
+	}
+}
 
 // ioErrConn is a Conn which tracks the last net.Error which was seen either
 // during an Encode call or a Decode call
@@ -27,13 +87,27 @@ type ioErrConn struct {
 	// level error, e.g. a timeout, disconnect, etc... Close is automatically
 	// called on the client when it encounters a critical network error
 	lastIOErr error
+
+	// createdAt is when the Conn was created, and lastUsedAt when it was last
+	// put back into a Pool. Both are only used by Pool.
+	createdAt, lastUsedAt time.Time
+
+	// state tracks the state of the connection, so a Pool can reset it before
+	// reusing the connection.
+	state connState
+
+	// breakerSeq is the Pool's breakerSeq at the time the Conn was retrieved
+	// from it, see recordResult.
+	breakerSeq uint64
 }
 
 func newIOErrConn(c Conn) *ioErrConn {
-	return &ioErrConn{Conn: c}
+	now := time.Now()
+	return &ioErrConn{Conn: c, createdAt: now, lastUsedAt: now}
 }
 
 func (ioc *ioErrConn) Encode(m resp.Marshaler) error {
+	ioc.state.trackEncode(m)
 	err := ioc.Conn.Encode(m)
 	if nerr, _ := err.(net.Error); nerr != nil {
 		ioc.lastIOErr = err
@@ -42,6 +116,7 @@ func (ioc *ioErrConn) Encode(m resp.Marshaler) error {
 }
 
 func (ioc *ioErrConn) Decode(m resp.Unmarshaler) error {
+	ioc.state.trackDecode()
 	err := ioc.Conn.Decode(m)
 	if nerr, _ := err.(net.Error); nerr != nil {
 		ioc.lastIOErr = err
@@ -71,6 +146,17 @@ type poolOpts struct {
 	pipelineConcurrency   int
 	pipelineLimit         int
 	pipelineWindow        time.Duration
+	pipelineAdaptive      bool
+	maxConnLifetime       time.Duration
+	maxIdleTime           time.Duration
+	adaptiveMin           int
+	adaptiveMax           int
+	breakerThreshold      int
+	fifoWait              bool
+	maxQueueLen           int
+	blockingSize          int
+	blockingOpts          []PoolOpt
+	pt                    trace.PoolTrace
 }
 
 // PoolOpt is an optional behavior which can be applied to the NewPool function
@@ -146,6 +232,25 @@ func PoolOnEmptyErrAfter(wait time.Duration) PoolOpt {
 	}
 }
 
+// PoolFIFOWait effects the Pool's behavior when there are no available
+// connections in the Pool. The effect is that Actions waiting for a connection,
+// as configured by the PoolOnEmpty options, are queued, and connections which
+// become available are given to them in the order they started waiting. Each
+// Action waits until its own deadline, after which it is removed from the
+// queue. The deadline is given by the PoolOnEmpty options, unless the Action is
+// a PoolDeadlineAction with a deadline of its own. Without this option, which
+// waiting Action gets a connection is arbitrary, so some may wait much longer
+// than others under load.
+//
+// If maxQueueLen is greater than zero and that many Actions are waiting
+// already, ErrPoolQueueFull is returned immediately rather than waiting.
+func PoolFIFOWait(maxQueueLen int) PoolOpt {
+	return func(po *poolOpts) {
+		po.fifoWait = true
+		po.maxQueueLen = maxQueueLen
+	}
+}
+
 // PoolOnFullClose effects the Pool's behavior when it is full. The effect is to
 // cause any connection which is being put back into a full pool to be closed
 // and discarded.
@@ -196,20 +301,221 @@ func PoolPipelineWindow(window time.Duration, limit int) PoolOpt {
 	return func(po *poolOpts) {
 		po.pipelineLimit = limit
 		po.pipelineWindow = window
+		po.pipelineAdaptive = false
+	}
+}
+
+// PoolPipelineAdaptiveWindow is like PoolPipelineWindow, except that maxWindow
+// is only the maximum duration after which internal pipelines will be flushed.
+// A pipeline is flushed right away if no other concurrent calls to Do may
+// still join it, so a single Action isn't delayed at low load, and otherwise
+// the window grows with the number of those calls, up to maxWindow.
+//
+// If maxWindow is zero then automatic pipelining will be disabled.
+func PoolPipelineAdaptiveWindow(maxWindow time.Duration, limit int) PoolOpt {
+	return func(po *poolOpts) {
+		po.pipelineLimit = limit
+		po.pipelineWindow = maxWindow
+		po.pipelineAdaptive = true
+	}
+}
+
+// PoolMaxConnLifetime tells the Pool to close connections which were created
+// more than d ago, and replace them with new ones. This allows connections to
+// be rebalanced, e.g. after scaling the instances behind a load balancer.
+//
+// Connections are checked when they are retrieved from and put back into the
+// Pool, as well as periodically while they are available in the Pool.
+// Connections which are in use are not interrupted.
+//
+// If d is zero, which is the default, connections are never closed due to
+// their age.
+func PoolMaxConnLifetime(d time.Duration) PoolOpt {
+	return func(po *poolOpts) {
+		po.maxConnLifetime = d
+	}
+}
+
+// PoolMaxIdleTime tells the Pool to close connections which weren't used for
+// more than d, and replace them with new ones. This avoids using connections
+// which were silently dropped, e.g. by a load balancer killing idle sessions.
+//
+// Connections are checked when they are retrieved from the Pool, as well as
+// periodically while they are available in the Pool.
+//
+// If d is zero, which is the default, connections are never closed due to
+// being idle.
+func PoolMaxIdleTime(d time.Duration) PoolOpt {
+	return func(po *poolOpts) {
+		po.maxIdleTime = d
+	}
+}
+
+// PoolAdaptiveSize tells the Pool to adjust its size between min and max based
+// on its usage. On each refill event (see PoolRefillInterval) the size is
+// increased by one if an Action had to wait for a connection since the last
+// refill event, or decreased by one if at least one connection was available
+// the whole time. Connections beyond the new size are closed by the drain of
+// the overflow buffer (see PoolOnFullBuffer), or when they are put back into a
+// full Pool.
+//
+// The size given to NewPool is used as the initial size, limited to be within
+// min and max. SetSize can still be used to change the size, which will then
+// be adjusted starting from the new size.
+func PoolAdaptiveSize(min, max int) PoolOpt {
+	return func(po *poolOpts) {
+		po.adaptiveMin = min
+		po.adaptiveMax = max
+	}
+}
+
+// PoolCircuitBreaker tells the Pool to stop using its redis instance after
+// threshold consecutive failures, where a failure is either an error creating
+// a connection or a network error on an existing one. A success only resets
+// the count of failures if no other failure happened since the connection was
+// retrieved, so that failures of concurrent Actions add up. While the circuit
+// is open all Actions fail right away with a *CircuitOpenError, rather than
+// each waiting for the dial timeout, and all available connections are closed,
+// as is every connection still in use once it's put back.
+//
+// On each ping event (see PoolPingInterval) the Pool half-opens the circuit by
+// creating a new connection and performing a PING on it. If that succeeds the
+// circuit is closed and the Pool is refilled. If pinging is disabled the refill
+// interval is used for this instead.
+//
+// If threshold is zero, which is the default, the circuit breaker is disabled.
+func PoolCircuitBreaker(threshold int) PoolOpt {
+	return func(po *poolOpts) {
+		po.breakerThreshold = threshold
+	}
+}
+
+// PoolBlockingConns tells the Pool to perform blocking commands, e.g. BLPOP,
+// XREAD with BLOCK or WAIT, as well as LongRunningActions, on a separate set of
+// size connections, so that they don't occupy the connections used for other
+// Actions while they block. The set is a Pool itself, which uses the same
+// ConnFunc and is created with the given options.
+//
+// While an Action is performed on a blocking connection, the connection's read
+// timeout is extended by the time the Action may block for, or removed if that
+// time is unlimited. This only works for connections created by Dial, so a
+// custom ConnFunc should use a read timeout which exceeds the blocking time.
+//
+// If size is zero, which is the default, blocking commands are performed on
+// the normal connections.
+func PoolBlockingConns(size int, opts ...PoolOpt) PoolOpt {
+	return func(po *poolOpts) {
+		po.blockingSize = size
+		po.blockingOpts = opts
+	}
+}
+
+// PoolWithTrace tells the Pool to call the callbacks of the given PoolTrace
+// when the respective events happen within it.
+func PoolWithTrace(pt trace.PoolTrace) PoolOpt {
+	return func(po *poolOpts) {
+		po.pt = pt
 	}
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
+// PoolStats describes the state of a Pool at a point in time, as well as the
+// number of events which happened within it since it was created. See the
+// Stats method.
+type PoolStats struct {
+	// Size is the number of connections the Pool keeps open, and BufferSize is
+	// the size of its overflow buffer.
+	Size, BufferSize int
+
+	// TotalConns is the number of connections currently open, including those
+	// in use. AvailConns is the number of connections currently available in
+	// the Pool, of which OverflowConns are in the overflow buffer.
+	TotalConns, AvailConns, OverflowConns int
+
+	// ConnsCreated and ConnsClosed are the number of connections created and
+	// closed by the Pool, and DialErrors the number of connections which failed
+	// to be created.
+	ConnsCreated, ConnsClosed, DialErrors uint64
+
+	// Gets is the number of times a connection was retrieved from the Pool in
+	// order to perform an Action on it, where all Actions which are pipelined
+	// automatically together only retrieve one. GetWaitTime is the total time spent waiting for
+	// connections to become available, and EmptyTimeouts the number of times
+	// none became available within the configured wait time.
+	Gets          uint64
+	GetWaitTime   time.Duration
+	EmptyTimeouts uint64
+
+	// OverflowPuts is the number of times a connection was put into the
+	// overflow buffer.
+	OverflowPuts uint64
+
+	// PingFailures is the number of periodic PINGs which failed.
+	PingFailures uint64
+
+	// PipelineFlushes is the number of pipelines performed by the automatic
+	// pipelining, and PipelineCmds the number of commands in them.
+	PipelineFlushes, PipelineCmds uint64
+
+	// PipelineBatchSizes is a histogram of the number of commands in the
+	// pipelines performed by the automatic pipelining. The element at index i
+	// is the number of pipelines with up to 1<<i commands, and more than half
+	// of that, while the last element also includes all larger pipelines.
+	PipelineBatchSizes [8]uint64
+
+	// CircuitOpen is whether the circuit breaker is currently open (see
+	// PoolCircuitBreaker). CircuitOpens is the number of times it opened, and
+	// CircuitFastFails the number of times a connection couldn't be retrieved
+	// because it was open.
+	CircuitOpen                    bool
+	CircuitOpens, CircuitFastFails uint64
+
+	// WaitQueueLen is the number of Actions currently waiting for a
+	// connection, and WaitQueuePeak the highest number which waited at once,
+	// if PoolFIFOWait is used. WaitQueueRejections is the number of times
+	// ErrPoolQueueFull was returned.
+	WaitQueueLen, WaitQueuePeak int
+	WaitQueueRejections         uint64
+
+	// Blocking is the PoolStats of the blocking connections if
+	// PoolBlockingConns is used, and nil otherwise.
+	Blocking *PoolStats
+}
+
+// poolCounters contains the counters of PoolStats, which are only accessed
+// atomically. It's allocated separately from the Pool to guarantee alignment.
+type poolCounters struct {
+	connsCreated, connsClosed, dialErrors uint64
+	gets, getWaitNanos, emptyTimeouts     uint64
+	overflowPuts, pingFailures            uint64
+	pipelineFlushes, pipelineCmds         uint64
+	circuitOpens, circuitFastFails        uint64
+	waitQueueRejections                   uint64
+	pipelineBatchSizes                    [8]uint64
+}
+
+// pipelineBatchSizeIndex returns the index of the PipelineBatchSizes element
+// which counts pipelines of numCmds commands.
+func pipelineBatchSizeIndex(numCmds int) int {
+	i := 0
+	for i < len(PoolStats{}.PipelineBatchSizes)-1 && 1<<uint(i) < numCmds {
+		i++
+	}
+	return i
+}
+
 // Pool is a semi-dynamic pool which holds a fixed number of connections open
 // and which implements the Client interface. It takes in a number of options
 // which can effect its specific behavior, see the NewPool method.
 type Pool struct {
 	opts          poolOpts
 	network, addr string
-	size          int
 
 	l sync.RWMutex
+	// size is the number of connections the pool keeps open, it's protected by
+	// l since it can be changed using SetSize.
+	size int
 	// totalConns is only really needed by the refill part of the code to ensure
 	// it's not overly refilling the pool. It is protected by l.
 	totalConns int
@@ -220,6 +526,43 @@ type Pool struct {
 
 	pipeliner *pipeliner
 
+	// blocking is the Pool blocking commands are performed on if
+	// PoolBlockingConns is used, and nil otherwise.
+	blocking *Pool
+
+	counters *poolCounters
+
+	// refillCh is written to when connections were closed due to their age or
+	// state, or the size was increased, so the refill creates new ones right
+	// away.
+	refillCh chan struct{}
+
+	// adaptMinAvail is the lowest number of available connections seen since
+	// the last refill event, and is only accessed atomically. adaptLastWait
+	// and adaptLastEmptyTimeouts are the counters seen on the last refill
+	// event, and are only accessed by the refill.
+	adaptMinAvail          int64
+	adaptLastWait          uint64
+	adaptLastEmptyTimeouts uint64
+
+	// breakerL protects the state of the circuit breaker. breakerFailures is
+	// the number of consecutive failures, and breakerErr the error which
+	// opened the circuit, which is nil while the circuit is closed. breakerSeq
+	// is incremented on each failure and each time the circuit opens or
+	// closes, and breakerEpoch is its value when that last happened.
+	breakerL        sync.Mutex
+	breakerFailures int
+	breakerErr      error
+	breakerSeq      uint64
+	breakerEpoch    uint64
+
+	// waiters is the queue of *poolWaiters if PoolFIFOWait is used, and is
+	// nil otherwise. It and waitersPeak are protected by waitL, which must be
+	// locked after l if both are locked.
+	waitL       sync.Mutex
+	waiters     *list.List
+	waitersPeak int
+
 	wg       sync.WaitGroup
 	closeCh  chan bool
 	initDone chan struct{} // used for tests
@@ -244,6 +587,8 @@ func NewPool(network, addr string, size int, opts ...PoolOpt) (*Pool, error) {
 		network:  network,
 		addr:     addr,
 		size:     size,
+		counters: new(poolCounters),
+		refillCh: make(chan struct{}, 1),
 		closeCh:  make(chan bool),
 		initDone: make(chan struct{}),
 	}
@@ -267,12 +612,24 @@ func NewPool(network, addr string, size int, opts ...PoolOpt) (*Pool, error) {
 		}
 	}
 
+	if p.opts.adaptiveMax > 0 {
+		if size < p.opts.adaptiveMin {
+			size = p.opts.adaptiveMin
+		} else if size > p.opts.adaptiveMax {
+			size = p.opts.adaptiveMax
+		}
+		p.size = size
+	}
+
 	totalSize := size + p.opts.overflowSize
 	p.pool = make(chan *ioErrConn, totalSize)
+	if p.opts.fifoWait {
+		p.waiters = list.New()
+	}
 
 	// make one Conn synchronously to ensure there's actually a redis instance
 	// present. The rest will be created asynchronously.
-	ioc, err := p.newConn(false) // false in case size is zero
+	ioc, err := p.newConn(trace.PoolConnCreatedReasonInitialization, false) // false in case size is zero
 	if err != nil {
 		return nil, err
 	}
@@ -282,11 +639,12 @@ func NewPool(network, addr string, size int, opts ...PoolOpt) (*Pool, error) {
 	go func() {
 		defer p.wg.Done()
 		for i := 0; i < size-1; i++ {
-			ioc, err := p.newConn(true)
+			// errors are reported through the PoolTrace and Stats, the
+			// refill will make up for any missing connections
+			ioc, err := p.newConn(trace.PoolConnCreatedReasonInitialization, true)
 			if err == nil {
 				p.put(ioc)
 			}
-			// TODO do something with that error?
 		}
 		close(p.initDone)
 	}()
@@ -303,41 +661,282 @@ func NewPool(network, addr string, size int, opts ...PoolOpt) (*Pool, error) {
 			p.opts.pipelineConcurrency,
 			p.opts.pipelineLimit,
 			p.opts.pipelineWindow,
+			p.opts.pipelineAdaptive,
 		)
+		p.pipeliner.onFlush = p.tracePipelineFlushed
 	}
 	if p.opts.pingInterval > 0 && size > 0 {
-		p.atIntervalDo(p.opts.pingInterval, func() { p.Do(Cmd(nil, "PING")) })
+		p.atIntervalDo(p.opts.pingInterval, p.doPing)
+	} else if p.opts.breakerThreshold > 0 && p.opts.refillInterval > 0 {
+		p.atIntervalDo(p.opts.refillInterval, p.doProbe)
 	}
-	if p.opts.refillInterval > 0 && size > 0 {
-		p.atIntervalDo(p.opts.refillInterval, p.doRefill)
+	if sweepInterval := p.sweepInterval(); sweepInterval > 0 {
+		p.atIntervalDo(sweepInterval, p.doSweep)
 	}
+	// the refill is always running, since SetSize may be called at any time
+	p.wg.Add(1)
+	go p.refillSpin()
 	if p.opts.overflowSize > 0 && p.opts.overflowDrainInterval > 0 {
 		p.atIntervalDo(p.opts.overflowDrainInterval, p.doOverflowDrain)
 	}
+	if p.opts.blockingSize > 0 {
+		blockingOpts := append([]PoolOpt{
+			PoolConnFunc(p.opts.cf),
+			PoolPipelineWindow(0, 0),
+		}, p.opts.blockingOpts...)
+		if p.blocking, err = NewPool(network, addr, p.opts.blockingSize, blockingOpts...); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
 	return p, nil
 }
 
+func (p *Pool) traceCommon() trace.PoolCommon {
+	p.l.RLock()
+	size := p.size
+	p.l.RUnlock()
+
+	return trace.PoolCommon{
+		Network:    p.network,
+		Addr:       p.addr,
+		PoolSize:   size,
+		BufferSize: p.opts.overflowSize,
+	}
+}
+
+func (p *Pool) traceConnCreated(reason trace.PoolConnCreatedReason, connectTime time.Duration, err error) {
+	if err != nil {
+		atomic.AddUint64(&p.counters.dialErrors, 1)
+	} else {
+		atomic.AddUint64(&p.counters.connsCreated, 1)
+	}
+	if p.opts.pt.ConnCreated != nil {
+		p.opts.pt.ConnCreated(trace.PoolConnCreated{
+			PoolCommon:  p.traceCommon(),
+			Reason:      reason,
+			ConnectTime: connectTime,
+			Err:         err,
+		})
+	}
+}
+
+func (p *Pool) traceConnClosed(reason trace.PoolConnClosedReason, availCount int, err error) {
+	atomic.AddUint64(&p.counters.connsClosed, 1)
+	if p.opts.pt.ConnClosed != nil {
+		p.opts.pt.ConnClosed(trace.PoolConnClosed{
+			PoolCommon: p.traceCommon(),
+			AvailCount: availCount,
+			Reason:     reason,
+			Err:        err,
+		})
+	}
+}
+
+func (p *Pool) traceGetCompleted(waitTime time.Duration, err error) {
+	atomic.AddUint64(&p.counters.gets, 1)
+	atomic.AddUint64(&p.counters.getWaitNanos, uint64(waitTime))
+	if p.opts.pt.GetCompleted != nil {
+		p.opts.pt.GetCompleted(trace.PoolGetCompleted{
+			PoolCommon: p.traceCommon(),
+			WaitTime:   waitTime,
+			Err:        err,
+		})
+	}
+}
+
+func (p *Pool) traceEmptyTimeout(waitTime time.Duration, err error) {
+	atomic.AddUint64(&p.counters.emptyTimeouts, 1)
+	if p.opts.pt.EmptyTimeout != nil {
+		p.opts.pt.EmptyTimeout(trace.PoolEmptyTimeout{
+			PoolCommon: p.traceCommon(),
+			WaitTime:   waitTime,
+			Err:        err,
+		})
+	}
+}
+
+func (p *Pool) traceOverflowUsed(overflowCount int) {
+	atomic.AddUint64(&p.counters.overflowPuts, 1)
+	if p.opts.pt.OverflowUsed != nil {
+		p.opts.pt.OverflowUsed(trace.PoolOverflowUsed{
+			PoolCommon:    p.traceCommon(),
+			OverflowCount: overflowCount,
+		})
+	}
+}
+
+func (p *Pool) tracePipelineFlushed(numCmds int, err error) {
+	atomic.AddUint64(&p.counters.pipelineFlushes, 1)
+	atomic.AddUint64(&p.counters.pipelineCmds, uint64(numCmds))
+	atomic.AddUint64(&p.counters.pipelineBatchSizes[pipelineBatchSizeIndex(numCmds)], 1)
+	if p.opts.pt.PipelineFlushed != nil {
+		p.opts.pt.PipelineFlushed(trace.PoolPipelineFlushed{
+			PoolCommon: p.traceCommon(),
+			NumCmds:    numCmds,
+			Err:        err,
+		})
+	}
+}
+
+func (p *Pool) traceCircuitChanged(err error) {
+	if err != nil {
+		atomic.AddUint64(&p.counters.circuitOpens, 1)
+	}
+	if p.opts.pt.CircuitChanged != nil {
+		p.opts.pt.CircuitChanged(trace.PoolCircuitChanged{
+			PoolCommon: p.traceCommon(),
+			Open:       err != nil,
+			Err:        err,
+		})
+	}
+}
+
+// circuitErr returns a *CircuitOpenError if the circuit breaker is open, or nil.
+func (p *Pool) circuitErr() error {
+	if p.opts.breakerThreshold <= 0 {
+		return nil
+	}
+
+	p.breakerL.Lock()
+	err := p.breakerErr
+	p.breakerL.Unlock()
+	if err == nil {
+		return nil
+	}
+	return &CircuitOpenError{Network: p.network, Addr: p.addr, Err: err}
+}
+
+// breakerSeqNow returns the current breakerSeq, to be passed to recordResult
+// once the connection retrieved or created after calling it was used.
+func (p *Pool) breakerSeqNow() uint64 {
+	if p.opts.breakerThreshold <= 0 {
+		return 0
+	}
+	p.breakerL.Lock()
+	defer p.breakerL.Unlock()
+	return p.breakerSeq
+}
+
+// recordResult records the outcome of creating or using a connection for the
+// circuit breaker, opening the circuit if there were too many consecutive
+// failures. While the circuit is open only doProbe closes it.
+//
+// seq is the breakerSeq from before the connection was retrieved or created.
+// Results of connections retrieved before the circuit last opened or closed are
+// ignored, and so is a success if another failure was recorded since, as it
+// doesn't mean that the failure is resolved.
+func (p *Pool) recordResult(err error, seq uint64) {
+	if p.opts.breakerThreshold <= 0 {
+		return
+	}
+
+	p.breakerL.Lock()
+	if seq < p.breakerEpoch || (err != nil && p.breakerErr != nil) {
+		p.breakerL.Unlock()
+		return
+	} else if err == nil {
+		if seq == p.breakerSeq {
+			p.breakerFailures = 0
+		}
+		p.breakerL.Unlock()
+		return
+	} else if p.breakerSeq, p.breakerFailures = p.breakerSeq+1, p.breakerFailures+1; p.breakerFailures < p.opts.breakerThreshold {
+		p.breakerL.Unlock()
+		return
+	}
+	p.breakerErr = err
+	p.breakerSeq++
+	p.breakerEpoch = p.breakerSeq
+	p.breakerL.Unlock()
+
+	p.traceCircuitChanged(err)
+	p.drainAvail(trace.PoolConnClosedReasonCircuitOpen)
+}
+
+// drainAvail closes all connections which are available in the pool.
+func (p *Pool) drainAvail(reason trace.PoolConnClosedReason) {
+	var toClose []*ioErrConn
+	p.l.RLock()
+	if p.closed {
+		p.l.RUnlock()
+		return
+	}
+drainLoop:
+	for {
+		select {
+		case ioc := <-p.pool:
+			toClose = append(toClose, ioc)
+		default:
+			break drainLoop
+		}
+	}
+	p.l.RUnlock()
+
+	for _, ioc := range toClose {
+		p.retire(ioc, reason)
+	}
+}
+
+// doProbe half-opens the circuit breaker, if it is open, by creating a new
+// connection and performing a PING on it. If that succeeds the circuit is
+// closed again and the pool is refilled, otherwise the connection is closed.
+func (p *Pool) doProbe() {
+	if p.circuitErr() == nil {
+		return
+	}
+
+	ioc, err := p.newConn(trace.PoolConnCreatedReasonRefill, false)
+	if err != nil {
+		return
+	} else if err = ioc.Do(Cmd(nil, "PING")); err != nil {
+		p.retire(ioc, trace.PoolConnClosedReasonCircuitOpen)
+		return
+	}
+
+	p.breakerL.Lock()
+	p.breakerFailures, p.breakerErr = 0, nil
+	p.breakerSeq++
+	p.breakerEpoch = p.breakerSeq
+	ioc.breakerSeq = p.breakerSeq
+	p.breakerL.Unlock()
+	p.traceCircuitChanged(nil)
+
+	p.put(ioc)
+	p.triggerRefill()
+}
+
 // this must always be called with p.l unlocked
-func (p *Pool) newConn(errIfFull bool) (*ioErrConn, error) {
+func (p *Pool) newConn(reason trace.PoolConnCreatedReason, errIfFull bool) (*ioErrConn, error) {
+	seq := p.breakerSeqNow()
+	start := time.Now()
 	c, err := p.opts.cf(p.network, p.addr)
+	p.traceConnCreated(reason, time.Since(start), err)
+	p.recordResult(err, seq)
 	if err != nil {
 		return nil, err
 	}
 	ioc := newIOErrConn(c)
+	ioc.breakerSeq = seq
 
 	// We don't want to wrap the entire function in a lock because dialing might
 	// take a while, but we also don't want to be making any new connections if
 	// the pool is closed
 	p.l.Lock()
-	defer p.l.Unlock()
 	if p.closed {
+		p.l.Unlock()
 		ioc.Close()
+		p.traceConnClosed(trace.PoolConnClosedReasonPoolClosed, 0, nil)
 		return nil, errClientClosed
 	} else if errIfFull && p.totalConns >= p.size {
+		availCount := len(p.pool)
+		p.l.Unlock()
 		ioc.Close()
+		p.traceConnClosed(trace.PoolConnClosedReasonPoolFull, availCount, nil)
 		return nil, errPoolFull
 	}
 	p.totalConns++
+	p.l.Unlock()
 
 	return ioc, nil
 }
@@ -359,22 +958,210 @@ func (p *Pool) atIntervalDo(d time.Duration, do func()) {
 	}()
 }
 
-func (p *Pool) doRefill() {
+// refillSpin calls doRefill on every refill event, and whenever connections
+// were closed due to their age until the pool is full again.
+func (p *Pool) refillSpin() {
+	defer p.wg.Done()
+
+	// a nil channel blocks forever, which is what's wanted if there are no
+	// refill events
+	var tc <-chan time.Time
+	if p.opts.refillInterval > 0 {
+		t := time.NewTicker(p.opts.refillInterval)
+		defer t.Stop()
+		tc = t.C
+	}
+
+	for {
+		select {
+		case <-tc:
+			if p.opts.adaptiveMax > 0 {
+				p.doAdapt()
+			}
+			p.doRefill()
+		case <-p.refillCh:
+			for p.doRefill() {
+			}
+		case <-p.closeCh:
+			return
+		}
+	}
+}
+
+// doAdapt adjusts the size of the pool as described by PoolAdaptiveSize.
+func (p *Pool) doAdapt() {
+	waitNanos := atomic.LoadUint64(&p.counters.getWaitNanos)
+	emptyTimeouts := atomic.LoadUint64(&p.counters.emptyTimeouts)
+	waited := waitNanos != p.adaptLastWait || emptyTimeouts != p.adaptLastEmptyTimeouts
+	p.adaptLastWait, p.adaptLastEmptyTimeouts = waitNanos, emptyTimeouts
+
+	p.l.RLock()
+	size, availConns := p.size, len(p.pool)
+	p.l.RUnlock()
+	minAvail := atomic.SwapInt64(&p.adaptMinAvail, int64(availConns))
+
+	if waited && size < p.opts.adaptiveMax {
+		p.SetSize(size + 1)
+	} else if !waited && minAvail > 0 && size > p.opts.adaptiveMin {
+		p.SetSize(size - 1)
+	}
+}
+
+// observeAvail records the number of available connections for doAdapt. p.l
+// must be read locked.
+func (p *Pool) observeAvail() {
+	if p.opts.adaptiveMax <= 0 {
+		return
+	}
+	availConns := int64(len(p.pool))
+	for {
+		minAvail := atomic.LoadInt64(&p.adaptMinAvail)
+		if availConns >= minAvail || atomic.CompareAndSwapInt64(&p.adaptMinAvail, minAvail, availConns) {
+			return
+		}
+	}
+}
+
+func (p *Pool) triggerRefill() {
+	select {
+	case p.refillCh <- struct{}{}:
+	default:
+	}
+}
+
+// doRefill creates a new connection and adds it to the pool if the pool isn't
+// full, and returns whether it did.
+func (p *Pool) doRefill() bool {
 	// this is a preliminary check to see if more conns are needed. Technically
 	// it's not needed, as newConn will do the same one, but it will also incur
 	// creating a connection and fully locking the mutex. We can handle the
 	// majority of cases here with a much less expensive read-lock.
 	p.l.RLock()
 	if p.totalConns >= p.size {
+		p.l.RUnlock()
+		return false
+	}
+	p.l.RUnlock()
+
+	// while the circuit is open only doProbe creates connections
+	if p.circuitErr() != nil {
+		return false
+	}
+
+	// errors creating the connection are reported through the PoolTrace and
+	// Stats, errPoolFull only means another routine filled the pool already
+	ioc, err := p.newConn(trace.PoolConnCreatedReasonRefill, true)
+	if err != nil {
+		return false
+	}
+	p.put(ioc)
+	return true
+}
+
+// sweepInterval returns the interval at which doSweep should be called, or 0
+// if connections aren't closed due to their age.
+func (p *Pool) sweepInterval() time.Duration {
+	d := p.opts.maxConnLifetime
+	if d <= 0 || (p.opts.maxIdleTime > 0 && p.opts.maxIdleTime < d) {
+		d = p.opts.maxIdleTime
+	}
+	if d <= 0 {
+		return 0
+	} else if d /= 2; d < time.Millisecond {
+		d = time.Millisecond
+	}
+	return d
+}
+
+// connExpired returns whether ioc should be closed due to its age, and why.
+func (p *Pool) connExpired(ioc *ioErrConn) (trace.PoolConnClosedReason, bool) {
+	if p.opts.maxConnLifetime <= 0 && p.opts.maxIdleTime <= 0 {
+		return "", false
+	}
+
+	now := time.Now()
+	if p.opts.maxConnLifetime > 0 && now.Sub(ioc.createdAt) > p.opts.maxConnLifetime {
+		return trace.PoolConnClosedReasonMaxLifetime, true
+	} else if p.opts.maxIdleTime > 0 && now.Sub(ioc.lastUsedAt) > p.opts.maxIdleTime {
+		return trace.PoolConnClosedReasonMaxIdleTime, true
+	}
+	return "", false
+}
+
+// retire closes ioc, which must not be in the pool, because of its age. The
+// caller is responsible for replacing it.
+func (p *Pool) retire(ioc *ioErrConn, reason trace.PoolConnClosedReason) {
+	ioc.Close()
+	p.l.Lock()
+	p.totalConns--
+	availCount := len(p.pool)
+	p.l.Unlock()
+	p.traceConnClosed(reason, availCount, nil)
+}
+
+// doSweep closes all connections available in the pool which have expired.
+func (p *Pool) doSweep() {
+	type retired struct {
+		ioc    *ioErrConn
+		reason trace.PoolConnClosedReason
+	}
+	var toRetire []retired
+
+	p.l.RLock()
+	if p.closed {
 		p.l.RUnlock()
 		return
 	}
+
+	// the pool is FIFO, so taking each available connection off and putting
+	// it back on if it hasn't expired keeps the order intact. Connections put
+	// back concurrently may be checked as well, which doesn't hurt.
+sweepLoop:
+	for n := len(p.pool); n > 0; n-- {
+		select {
+		case ioc := <-p.pool:
+			if reason, expired := p.connExpired(ioc); expired {
+				toRetire = append(toRetire, retired{ioc, reason})
+				continue
+			}
+
+			select {
+			case p.pool <- ioc:
+			default:
+				toRetire = append(toRetire, retired{ioc, trace.PoolConnClosedReasonPoolFull})
+			}
+		default:
+			break sweepLoop
+		}
+	}
 	p.l.RUnlock()
 
-	ioc, err := p.newConn(true)
-	// TODO do something with this error? not if it's errPoolFull
-	if err == nil {
-		p.put(ioc)
+	p.serveWaiters()
+	for _, r := range toRetire {
+		p.retire(r.ioc, r.reason)
+	}
+	if len(toRetire) > 0 {
+		p.triggerRefill()
+	}
+}
+
+func (p *Pool) doPing() {
+	if p.circuitErr() != nil {
+		p.doProbe()
+		return
+	}
+
+	err := p.Do(Cmd(nil, "PING"))
+	if err == nil || err == errClientClosed {
+		return
+	}
+
+	atomic.AddUint64(&p.counters.pingFailures, 1)
+	if p.opts.pt.PingFailed != nil {
+		p.opts.pt.PingFailed(trace.PoolPingFailed{
+			PoolCommon: p.traceCommon(),
+			Err:        err,
+		})
 	}
 }
 
@@ -404,70 +1191,344 @@ func (p *Pool) doOverflowDrain() {
 	ioc.Close()
 	p.l.Lock()
 	p.totalConns--
+	availCount := len(p.pool)
 	p.l.Unlock()
+	p.traceConnClosed(trace.PoolConnClosedReasonBufferDrain, availCount, nil)
 }
 
-func (p *Pool) getExisting() (*ioErrConn, error) {
-	p.l.RLock()
-	defer p.l.RUnlock()
+// emptyWait returns how long to wait for a connection if the pool is empty, -1
+// meaning forever, and the error to return if none became available, which may
+// be nil. If deadline isn't zero it's used instead of the PoolOnEmpty options.
+func (p *Pool) emptyWait(deadline time.Time) (time.Duration, error) {
+	if deadline.IsZero() {
+		return p.opts.onEmptyWait, p.opts.errOnEmpty
+	}
+	wait := time.Until(deadline)
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, ErrPoolEmpty
+}
+
+// getExisting returns an available connection, waiting for one as configured
+// if the pool is empty, and how long was waited. If no connection became
+// available then the error returned by emptyWait is returned, which may be
+// nil.
+func (p *Pool) getExisting(deadline time.Time) (*ioErrConn, time.Duration, error) {
+	if p.waiters != nil {
+		return p.getExistingFIFO(deadline)
+	}
+	onEmptyWait, errOnEmpty := p.emptyWait(deadline)
 
+	p.l.RLock()
 	if p.closed {
-		return nil, errClientClosed
+		p.l.RUnlock()
+		return nil, 0, errClientClosed
 	}
 
 	// Fast-path if the pool is not empty.
 	select {
 	case ioc := <-p.pool:
-		return ioc, nil
+		p.observeAvail()
+		p.l.RUnlock()
+		return ioc, 0, nil
 	default:
+		p.observeAvail()
 	}
 
-	if p.opts.onEmptyWait == 0 {
+	if onEmptyWait == 0 {
 		// If we should not wait we return without allocating a timer.
-		return nil, p.opts.errOnEmpty
+		p.l.RUnlock()
+		return nil, 0, errOnEmpty
 	}
 
+	// the lock isn't held while waiting, since SetSize and Close would
+	// otherwise block any put until the wait is over. Both of them close the
+	// channel to wake up those waiting on it.
+	pool := p.pool
+	p.l.RUnlock()
+
 	// only set when we have a timeout, since a nil channel always blocks which
 	// is what we want
 	var tc <-chan time.Time
-	if p.opts.onEmptyWait > 0 {
-		t := getTimer(p.opts.onEmptyWait)
+	if onEmptyWait > 0 {
+		t := getTimer(onEmptyWait)
 		defer putTimer(t)
 
 		tc = t.C
 	}
 
+	start := time.Now()
+	for {
+		select {
+		case ioc, ok := <-pool:
+			if ok {
+				return ioc, time.Since(start), nil
+			}
+		case <-tc:
+			return nil, time.Since(start), errOnEmpty
+		}
+
+		var closed bool
+		p.l.RLock()
+		closed, pool = p.closed, p.pool
+		p.l.RUnlock()
+		if closed {
+			return nil, time.Since(start), errClientClosed
+		}
+	}
+}
+
+// poolWaiter is queued by getExistingFIFO while waiting for a connection.
+type poolWaiter struct {
+	// ch is written to once the poolWaiter was removed from the queue in
+	// order to give it a connection, which is nil if the pool was closed.
+	// served is set at the same time, and is protected by waitL.
+	ch     chan *ioErrConn
+	served bool
+}
+
+// getExistingFIFO is like getExisting, except that it waits in the queue of
+// waiters for a connection if the pool is empty. See PoolFIFOWait.
+func (p *Pool) getExistingFIFO(deadline time.Time) (*ioErrConn, time.Duration, error) {
+	onEmptyWait, errOnEmpty := p.emptyWait(deadline)
+
+	p.l.RLock()
+	if p.closed {
+		p.l.RUnlock()
+		return nil, 0, errClientClosed
+	}
+
+	// a connection is only taken directly if no one is waiting already, so
+	// that those waiting aren't overtaken
+	p.waitL.Lock()
+	if p.waiters.Len() == 0 {
+		select {
+		case ioc := <-p.pool:
+			p.observeAvail()
+			p.waitL.Unlock()
+			p.l.RUnlock()
+			return ioc, 0, nil
+		default:
+			p.observeAvail()
+		}
+	}
+
+	if onEmptyWait == 0 {
+		p.waitL.Unlock()
+		p.l.RUnlock()
+		return nil, 0, errOnEmpty
+	} else if p.opts.maxQueueLen > 0 && p.waiters.Len() >= p.opts.maxQueueLen {
+		p.waitL.Unlock()
+		p.l.RUnlock()
+		atomic.AddUint64(&p.counters.waitQueueRejections, 1)
+		return nil, 0, ErrPoolQueueFull
+	}
+
+	w := &poolWaiter{ch: make(chan *ioErrConn, 1)}
+	elem := p.waiters.PushBack(w)
+	if n := p.waiters.Len(); n > p.waitersPeak {
+		p.waitersPeak = n
+	}
+	p.waitL.Unlock()
+	p.l.RUnlock()
+
+	// only set when we have a timeout, since a nil channel always blocks which
+	// is what we want
+	var tc <-chan time.Time
+	if onEmptyWait > 0 {
+		t := getTimer(onEmptyWait)
+		defer putTimer(t)
+
+		tc = t.C
+	}
+
+	start := time.Now()
 	select {
-	case ioc := <-p.pool:
-		return ioc, nil
+	case ioc := <-w.ch:
+		return p.served(ioc, time.Since(start))
 	case <-tc:
-		return nil, p.opts.errOnEmpty
+	}
+
+	// a connection might have been given to w after the timeout
+	p.waitL.Lock()
+	served := w.served
+	if !served {
+		p.waiters.Remove(elem)
+	}
+	p.waitL.Unlock()
+
+	if served {
+		return p.served(<-w.ch, time.Since(start))
+	}
+	return nil, time.Since(start), errOnEmpty
+}
+
+// served returns the result of getExistingFIFO for the connection a
+// poolWaiter was given.
+func (p *Pool) served(ioc *ioErrConn, waitTime time.Duration) (*ioErrConn, time.Duration, error) {
+	if ioc == nil {
+		return nil, waitTime, errClientClosed
+	}
+	return ioc, waitTime, nil
+}
+
+// serveWaiter gives ioc to the first poolWaiter in the queue, if there is one,
+// and returns whether it did. waitL must be locked.
+func (p *Pool) serveWaiter(ioc *ioErrConn) bool {
+	elem := p.waiters.Front()
+	if elem == nil {
+		return false
+	}
+	w := p.waiters.Remove(elem).(*poolWaiter)
+	w.served = true
+	w.ch <- ioc
+	return true
+}
+
+// serveWaiters gives available connections to waiting poolWaiters, in case
+// they were put back into the pool without checking for them.
+func (p *Pool) serveWaiters() {
+	if p.waiters == nil {
+		return
+	}
+
+	p.l.RLock()
+	defer p.l.RUnlock()
+	if p.closed {
+		return
+	}
+
+	p.waitL.Lock()
+	defer p.waitL.Unlock()
+	for p.waiters.Len() > 0 {
+		select {
+		case ioc := <-p.pool:
+			p.serveWaiter(ioc)
+		default:
+			return
+		}
+	}
+}
+
+// putAvail makes ioc available, by giving it to the first poolWaiter in the
+// queue if there is one, or by putting it into the pool, and returns false if
+// the pool is full. l must be read locked.
+func (p *Pool) putAvail(ioc *ioErrConn) bool {
+	if p.waiters != nil {
+		p.waitL.Lock()
+		defer p.waitL.Unlock()
+		if p.serveWaiter(ioc) {
+			return true
+		}
+	}
+
+	select {
+	case p.pool <- ioc:
+		return true
+	default:
+		return false
 	}
 }
 
 func (p *Pool) get() (*ioErrConn, error) {
-	ioc, err := p.getExisting()
-	if err != nil {
+	return p.getBefore(time.Time{})
+}
+
+// getBefore is like get, but if deadline isn't zero it waits for a connection
+// until then, instead of as configured by the PoolOnEmpty options.
+func (p *Pool) getBefore(deadline time.Time) (*ioErrConn, error) {
+	// the sequence is retrieved before checking the circuit, so that the
+	// result isn't recorded if it opened in between
+	seq := p.breakerSeqNow()
+	if err := p.circuitErr(); err != nil {
+		atomic.AddUint64(&p.counters.circuitFastFails, 1)
+		p.traceGetCompleted(0, err)
 		return nil, err
-	} else if ioc != nil {
-		return ioc, nil
 	}
 
-	// at this point everything is unlocked and the conn needs to be created.
-	// newConn will handle checking if the pool has been closed since the inner
-	// was called.
-	return p.newConn(false)
+	ioc, waitTime, err := p.getExisting(deadline)
+
+	// an expired connection is replaced by the refill, rather than on the
+	// caller's path, and another one is retrieved instead
+	for ioc != nil {
+		reason, expired := p.connExpired(ioc)
+		if !expired {
+			break
+		}
+		p.retire(ioc, reason)
+		p.triggerRefill()
+
+		var moreWaitTime time.Duration
+		ioc, moreWaitTime, err = p.getExisting(deadline)
+		waitTime += moreWaitTime
+	}
+
+	if ioc == nil && (err == p.opts.errOnEmpty || err == ErrPoolEmpty) {
+		p.traceEmptyTimeout(waitTime, err)
+	}
+
+	// at this point everything is unlocked and, if no error was returned, the
+	// conn needs to be created. newConn will handle checking if the pool has
+	// been closed since the inner was called.
+	if ioc == nil && err == nil {
+		ioc, err = p.newConn(trace.PoolConnCreatedReasonPoolEmpty, false)
+	}
+	if ioc != nil {
+		ioc.breakerSeq = seq
+	}
+
+	p.traceGetCompleted(waitTime, err)
+	return ioc, err
 }
 
 func (p *Pool) put(ioc *ioErrConn) {
+	if p.opts.maxIdleTime > 0 {
+		ioc.lastUsedAt = time.Now()
+	}
+	expiredReason, expired := p.connExpired(ioc)
+
+	p.recordResult(ioc.lastIOErr, ioc.breakerSeq)
+
+	// while the circuit is open no connections are kept, like when it opened
+	circuitOpen := p.circuitErr() != nil
+
+	// a connection which was left in a state that would affect the next
+	// Action performed on it, e.g. by a WithConn callback, is reset if
+	// possible, and closed otherwise. It's not reset if it's going to be closed
+	// anyway, since that requires performing commands on it.
+	var dirty bool
+	if ioc.lastIOErr == nil && !expired && !circuitOpen && !ioc.state.clean() {
+		p.l.RLock()
+		closed := p.closed
+		p.l.RUnlock()
+		dirty = closed || !ioc.state.reset(ioc)
+	}
+
 	p.l.RLock()
-	if ioc.lastIOErr == nil && !p.closed {
-		select {
-		case p.pool <- ioc:
-			p.l.RUnlock()
-			return
-		default:
+	if ioc.lastIOErr == nil && !p.closed && !expired && !circuitOpen && !dirty && p.putAvail(ioc) {
+		overflowCount := len(p.pool) - p.size
+		p.l.RUnlock()
+		if overflowCount > 0 {
+			p.traceOverflowUsed(overflowCount)
 		}
+		return
+	}
+
+	reason, ioErr := trace.PoolConnClosedReasonPoolFull, ioc.lastIOErr
+	if ioErr != nil {
+		reason = trace.PoolConnClosedReasonConnError
+	} else if p.closed {
+		reason = trace.PoolConnClosedReasonPoolClosed
+	} else if circuitOpen {
+		reason = trace.PoolConnClosedReasonCircuitOpen
+	} else if dirty {
+		reason = trace.PoolConnClosedReasonDirtyState
+	} else if expired {
+		p.l.RUnlock()
+		p.retire(ioc, expiredReason)
+		p.triggerRefill()
+		return
 	}
 
 	p.l.RUnlock()
@@ -476,28 +1537,57 @@ func (p *Pool) put(ioc *ioErrConn) {
 	ioc.Close()
 	p.l.Lock()
 	p.totalConns--
+	availCount := len(p.pool)
 	p.l.Unlock()
+	p.traceConnClosed(reason, availCount, ioErr)
+
+	// unlike a connection error, a dirty connection doesn't mean that new
+	// connections can't be created, so it's replaced right away
+	if reason == trace.PoolConnClosedReasonDirtyState {
+		p.triggerRefill()
+	}
 }
 
 // Do implements the Do method of the Client interface by retrieving a Conn out
 // of the pool, calling Run on the given Action with it, and returning the Conn
 // to the pool.
 //
-// If the given Action is a CmdAction, it will be pipelined with other concurrent
-// calls to Do, which can improve the performance and resource usage of the Redis
-// server, but will increase the latency for some of the Actions. To avoid the
-// automatic pipelining you can either set PoolPipelineWindow(0, 0) when creating the
-// Pool or use WithConn. Pipelines created manually (via Pipeline) are also excluded
-// from this and will be executed as if using WithConn.
+// If the given Action is a PipelineableAction, e.g. one created using Cmd,
+// FlatCmd or EvalScript.Cmd, or a Pipeline of at most 16 of those, it will be
+// pipelined with other concurrent calls to Do, which can improve the
+// performance and resource usage of the Redis server, but will increase the
+// latency for some of the Actions. Blocking commands like BLPOP are never
+// pipelined. To avoid the automatic pipelining you can either set
+// PoolPipelineWindow(0, 0) when creating the Pool or use WithConn.
+//
+// If PoolBlockingConns is used, Actions performing blocking commands and
+// LongRunningActions are performed on the blocking connections instead.
 //
-// Due to a limitation in the implementation, custom CmdAction implementations
-// are currently not automatically pipelined.
+// A pipelined EvalScript.Cmd Action whose script isn't loaded on the server yet
+// is performed again on its own, which loads the script.
+//
+// If an Action, e.g. one created using WithConn, leaves its Conn in a
+// transaction or with WATCHed keys, the Pool resets the Conn using DISCARD or
+// UNWATCH before reusing it. If the Conn was left in a state which can't be
+// reset, e.g. subscribed, with a different DB SELECTed, with CLIENT REPLY OFF
+// or with replies which weren't read, it is closed instead. Only the commands
+// of CmdActions created by this package and the PipelineCmds of
+// PipelineableActions are known, the Conn is assumed to be left as it was by
+// anything else encoded on it.
 func (p *Pool) Do(a Action) error {
+	if p.blocking != nil {
+		if timeout, ok := blockingTimeout(a); ok {
+			return p.blocking.Do(blockingAction{Action: a, timeout: timeout})
+		}
+	}
+
 	if p.pipeliner != nil && p.pipeliner.CanDo(a) {
-		return p.pipeliner.Do(a)
+		if err := p.pipeliner.Do(a); !isPipelinedNoScript(a, err) {
+			return err
+		}
 	}
 
-	c, err := p.get()
+	c, err := p.getBefore(poolDeadline(a))
 	if err != nil {
 		return err
 	}
@@ -506,12 +1596,136 @@ func (p *Pool) Do(a Action) error {
 	return c.Do(a)
 }
 
+// blockingAction wraps an Action which is performed on a blocking connection,
+// and extends the connection's read timeout by the time it may block for while
+// it's running.
+type blockingAction struct {
+	Action
+	timeout time.Duration
+}
+
+func (ba blockingAction) Run(conn Conn) error {
+	defer extendReadTimeoutUnder(conn, ba.timeout)()
+	return ba.Action.Run(conn)
+}
+
 // NumAvailConns returns the number of connections currently available in the
 // pool, as well as in the overflow buffer if that option is enabled.
 func (p *Pool) NumAvailConns() int {
+	p.l.RLock()
+	defer p.l.RUnlock()
 	return len(p.pool)
 }
 
+// SetSize changes the number of connections the Pool keeps open. If the size
+// is increased, new connections are created by the refill right away. If it is
+// decreased, available connections which don't fit into the Pool and its
+// overflow buffer anymore are closed right away, and the overflow buffer is
+// drained as usual. Connections which are in use are not interrupted.
+func (p *Pool) SetSize(size int) error {
+	if size < 0 {
+		return errors.New("pool size must not be negative")
+	}
+
+	var toClose []*ioErrConn
+	p.l.Lock()
+	if p.closed {
+		p.l.Unlock()
+		return errClientClosed
+	}
+
+	// connections are only put into the pool while the lock is held, so the
+	// old channel can be replaced safely. It's closed once it's empty, so that
+	// routines waiting on it wait on the new one instead.
+	newPool := make(chan *ioErrConn, size+p.opts.overflowSize)
+moveLoop:
+	for {
+		select {
+		case ioc := <-p.pool:
+			select {
+			case newPool <- ioc:
+			default:
+				toClose = append(toClose, ioc)
+				p.totalConns--
+			}
+		default:
+			close(p.pool)
+			break moveLoop
+		}
+	}
+	p.pool, p.size = newPool, size
+	availCount := len(p.pool)
+	p.l.Unlock()
+
+	p.serveWaiters()
+	for _, ioc := range toClose {
+		ioc.Close()
+		p.traceConnClosed(trace.PoolConnClosedReasonPoolResized, availCount, nil)
+	}
+	p.triggerRefill()
+	return nil
+}
+
+// Stats returns the current PoolStats of the Pool.
+func (p *Pool) Stats() PoolStats {
+	p.l.RLock()
+	size, totalConns, availConns := p.size, p.totalConns, len(p.pool)
+	p.l.RUnlock()
+
+	overflowConns := availConns - size
+	if overflowConns < 0 {
+		overflowConns = 0
+	}
+
+	var waitQueueLen, waitQueuePeak int
+	if p.waiters != nil {
+		p.waitL.Lock()
+		waitQueueLen, waitQueuePeak = p.waiters.Len(), p.waitersPeak
+		p.waitL.Unlock()
+	}
+
+	var batchSizes [8]uint64
+	for i := range batchSizes {
+		batchSizes[i] = atomic.LoadUint64(&p.counters.pipelineBatchSizes[i])
+	}
+
+	var blocking *PoolStats
+	if p.blocking != nil {
+		blockingStats := p.blocking.Stats()
+		blocking = &blockingStats
+	}
+
+	return PoolStats{
+		Size:            size,
+		BufferSize:      p.opts.overflowSize,
+		TotalConns:      totalConns,
+		AvailConns:      availConns,
+		OverflowConns:   overflowConns,
+		ConnsCreated:    atomic.LoadUint64(&p.counters.connsCreated),
+		ConnsClosed:     atomic.LoadUint64(&p.counters.connsClosed),
+		DialErrors:      atomic.LoadUint64(&p.counters.dialErrors),
+		Gets:            atomic.LoadUint64(&p.counters.gets),
+		GetWaitTime:     time.Duration(atomic.LoadUint64(&p.counters.getWaitNanos)),
+		EmptyTimeouts:   atomic.LoadUint64(&p.counters.emptyTimeouts),
+		OverflowPuts:    atomic.LoadUint64(&p.counters.overflowPuts),
+		PingFailures:    atomic.LoadUint64(&p.counters.pingFailures),
+		PipelineFlushes: atomic.LoadUint64(&p.counters.pipelineFlushes),
+		PipelineCmds:    atomic.LoadUint64(&p.counters.pipelineCmds),
+
+		PipelineBatchSizes: batchSizes,
+
+		CircuitOpen:      p.circuitErr() != nil,
+		CircuitOpens:     atomic.LoadUint64(&p.counters.circuitOpens),
+		CircuitFastFails: atomic.LoadUint64(&p.counters.circuitFastFails),
+
+		WaitQueueLen:        waitQueueLen,
+		WaitQueuePeak:       waitQueuePeak,
+		WaitQueueRejections: atomic.LoadUint64(&p.counters.waitQueueRejections),
+
+		Blocking: blocking,
+	}
+}
+
 // Close implements the Close method of the Client
 func (p *Pool) Close() error {
 	p.l.Lock()
@@ -522,14 +1736,24 @@ func (p *Pool) Close() error {
 	p.closed = true
 	close(p.closeCh)
 
+	if p.waiters != nil {
+		p.waitL.Lock()
+		for p.waiters.Len() > 0 {
+			p.serveWaiter(nil)
+		}
+		p.waitL.Unlock()
+	}
+
 	// at this point get and put won't work anymore, so it's safe to empty and
 	// close the pool channel
+	var numClosed int
 emptyLoop:
 	for {
 		select {
 		case ioc := <-p.pool:
 			ioc.Close()
 			p.totalConns--
+			numClosed++
 		default:
 			close(p.pool)
 			break emptyLoop
@@ -537,12 +1761,23 @@ emptyLoop:
 	}
 	p.l.Unlock()
 
+	// the trace is called outside of the lock, in case it calls Stats
+	for i := numClosed - 1; i >= 0; i-- {
+		p.traceConnClosed(trace.PoolConnClosedReasonPoolClosed, i, nil)
+	}
+
 	if p.pipeliner != nil {
 		if err := p.pipeliner.Close(); err != nil {
 			return err
 		}
 	}
 
+	if p.blocking != nil {
+		if err := p.blocking.Close(); err != nil {
+			return err
+		}
+	}
+
 	// by now the pool's go-routines should have bailed, wait to make sure they
 	// do
 	p.wg.Wait()
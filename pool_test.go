@@ -1,12 +1,21 @@
 package radix
 
 import (
+	"errors"
 	"io"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	. "testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vikram-suki/radix/v3/resp"
+	"github.com/vikram-suki/radix/v3/resp/resp2"
+	"github.com/vikram-suki/radix/v3/trace"
 )
 
 func testPool(size int, opts ...PoolOpt) *Pool {
@@ -101,7 +110,7 @@ func TestPoolOnFull(t *T) {
 		defer pool.Close()
 		assert.Equal(t, 1, len(pool.pool))
 
-		spc, err := pool.newConn(false)
+		spc, err := pool.newConn(trace.PoolConnCreatedReasonPoolEmpty, false)
 		assert.NoError(t, err)
 		pool.put(spc)
 		assert.Equal(t, 1, len(pool.pool))
@@ -113,13 +122,13 @@ func TestPoolOnFull(t *T) {
 		assert.Equal(t, 1, len(pool.pool))
 
 		// putting a conn should overflow
-		spc, err := pool.newConn(false)
+		spc, err := pool.newConn(trace.PoolConnCreatedReasonPoolEmpty, false)
 		assert.NoError(t, err)
 		pool.put(spc)
 		assert.Equal(t, 2, len(pool.pool))
 
 		// another shouldn't, overflow is full
-		spc, err = pool.newConn(false)
+		spc, err = pool.newConn(trace.PoolConnCreatedReasonPoolEmpty, false)
 		assert.NoError(t, err)
 		pool.put(spc)
 		assert.Equal(t, 2, len(pool.pool))
@@ -132,7 +141,7 @@ func TestPoolOnFull(t *T) {
 		assert.Equal(t, 1, len(pool.pool))
 
 		// if both are full then drain should remove the overflow one
-		spc, err = pool.newConn(false)
+		spc, err = pool.newConn(trace.PoolConnCreatedReasonPoolEmpty, false)
 		assert.NoError(t, err)
 		pool.put(spc)
 		assert.Equal(t, 2, len(pool.pool))
@@ -197,4 +206,1023 @@ func TestPoolClose(t *T) {
 	assert.NoError(t, pool.Do(Cmd(nil, "PING")))
 	assert.NoError(t, pool.Close())
 	assert.Error(t, errClientClosed, pool.Do(Cmd(nil, "PING")))
-}
\ No newline at end of file
+}
+
+func TestPoolTrace(t *T) {
+	var l sync.Mutex
+	var created []trace.PoolConnCreatedReason
+	var closed []trace.PoolConnClosedReason
+	var emptyTimeouts, overflowCounts, flushSizes []int
+	pt := trace.PoolTrace{
+		ConnCreated: func(e trace.PoolConnCreated) {
+			l.Lock()
+			defer l.Unlock()
+			assert.NoError(t, e.Err)
+			created = append(created, e.Reason)
+		},
+		ConnClosed: func(e trace.PoolConnClosed) {
+			l.Lock()
+			defer l.Unlock()
+			closed = append(closed, e.Reason)
+		},
+		EmptyTimeout: func(e trace.PoolEmptyTimeout) {
+			l.Lock()
+			defer l.Unlock()
+			assert.NoError(t, e.Err)
+			emptyTimeouts = append(emptyTimeouts, int(e.WaitTime))
+		},
+		OverflowUsed: func(e trace.PoolOverflowUsed) {
+			l.Lock()
+			defer l.Unlock()
+			overflowCounts = append(overflowCounts, e.OverflowCount)
+		},
+		PipelineFlushed: func(e trace.PoolPipelineFlushed) {
+			l.Lock()
+			defer l.Unlock()
+			assert.NoError(t, e.Err)
+			flushSizes = append(flushSizes, e.NumCmds)
+		},
+	}
+
+	connFunc := func(network, addr string) (Conn, error) {
+		return Stub(network, addr, func(args []string) interface{} {
+			return args[0]
+		}), nil
+	}
+
+	pool, err := NewPool("tcp", "127.0.0.1:6379", 2,
+		PoolConnFunc(connFunc),
+		PoolOnEmptyCreateAfter(0),
+		PoolOnFullBuffer(1, 0),
+		PoolPingInterval(0),
+		PoolRefillInterval(0),
+		PoolWithTrace(pt),
+	)
+	require.NoError(t, err)
+	<-pool.initDone
+
+	// hold all connections, so a third one needs to be created, which ends up
+	// in the overflow buffer once all are put back. The pipeline of the PING
+	// uses the overflow buffer again.
+	noop := func(Conn) error { return nil }
+	assert.NoError(t, pool.Do(WithConn("", func(Conn) error {
+		return pool.Do(WithConn("", func(Conn) error {
+			return pool.Do(WithConn("", noop))
+		}))
+	})))
+	assert.NoError(t, pool.Do(Cmd(nil, "PING")))
+
+	stats := pool.Stats()
+	assert.Equal(t, 3, stats.TotalConns)
+	assert.Equal(t, 3, stats.AvailConns)
+	assert.Equal(t, 1, stats.OverflowConns)
+	assert.Equal(t, uint64(3), stats.ConnsCreated)
+	assert.Equal(t, uint64(4), stats.Gets)
+	assert.Equal(t, uint64(1), stats.EmptyTimeouts)
+	assert.Equal(t, uint64(2), stats.OverflowPuts)
+	assert.Equal(t, uint64(1), stats.PipelineFlushes)
+	assert.Equal(t, uint64(1), stats.PipelineCmds)
+
+	require.NoError(t, pool.Close())
+	assert.Equal(t, uint64(3), pool.Stats().ConnsClosed)
+
+	l.Lock()
+	defer l.Unlock()
+	assert.Equal(t, []trace.PoolConnCreatedReason{
+		trace.PoolConnCreatedReasonInitialization,
+		trace.PoolConnCreatedReasonInitialization,
+		trace.PoolConnCreatedReasonPoolEmpty,
+	}, created)
+	assert.Equal(t, []trace.PoolConnClosedReason{
+		trace.PoolConnClosedReasonPoolClosed,
+		trace.PoolConnClosedReasonPoolClosed,
+		trace.PoolConnClosedReasonPoolClosed,
+	}, closed)
+	assert.Equal(t, []int{0}, emptyTimeouts)
+	assert.Equal(t, []int{1, 1}, overflowCounts)
+	assert.Equal(t, []int{1}, flushSizes)
+
+	t.Run("dialError", func(t *T) {
+		dialErr := errors.New("dial error")
+		var traceErr error
+		_, err := NewPool("tcp", "127.0.0.1:6379", 1,
+			PoolConnFunc(func(string, string) (Conn, error) { return nil, dialErr }),
+			PoolWithTrace(trace.PoolTrace{
+				ConnCreated: func(e trace.PoolConnCreated) { traceErr = e.Err },
+			}),
+		)
+		assert.Equal(t, dialErr, err)
+		assert.Equal(t, dialErr, traceErr)
+	})
+}
+
+func TestPoolMaxConnAge(t *T) {
+	connFunc := func(network, addr string) (Conn, error) {
+		return Stub(network, addr, func(args []string) interface{} {
+			return args[0]
+		}), nil
+	}
+
+	do := func(t *T, expReason trace.PoolConnClosedReason, opts ...PoolOpt) {
+		var l sync.Mutex
+		reasons := map[trace.PoolConnClosedReason]int{}
+		opts = append([]PoolOpt{
+			PoolConnFunc(connFunc),
+			PoolPingInterval(0),
+			PoolRefillInterval(time.Hour),
+			PoolWithTrace(trace.PoolTrace{
+				ConnClosed: func(e trace.PoolConnClosed) {
+					l.Lock()
+					defer l.Unlock()
+					reasons[e.Reason]++
+				},
+			}),
+		}, opts...)
+
+		pool, err := NewPool("tcp", "127.0.0.1:6379", 2, opts...)
+		require.NoError(t, err)
+		defer pool.Close()
+		<-pool.initDone
+
+		// both connections get replaced, without waiting for a refill event
+		assert.Eventually(t, func() bool {
+			l.Lock()
+			defer l.Unlock()
+			return reasons[expReason] >= 2 && pool.Stats().TotalConns == 2
+		}, time.Second, 10*time.Millisecond)
+		assert.NoError(t, pool.Do(Cmd(nil, "PING")))
+
+		l.Lock()
+		defer l.Unlock()
+		assert.Len(t, reasons, 1)
+	}
+
+	t.Run("maxLifetime", func(t *T) {
+		do(t, trace.PoolConnClosedReasonMaxLifetime, PoolMaxConnLifetime(50*time.Millisecond))
+	})
+	t.Run("maxIdleTime", func(t *T) {
+		do(t, trace.PoolConnClosedReasonMaxIdleTime, PoolMaxIdleTime(50*time.Millisecond))
+	})
+
+	t.Run("get", func(t *T) {
+		var l sync.Mutex
+		var created []trace.PoolConnCreatedReason
+		pool, err := NewPool("tcp", "127.0.0.1:6379", 1,
+			PoolConnFunc(connFunc),
+			PoolPingInterval(0),
+			PoolPipelineWindow(0, 0),
+			PoolMaxIdleTime(time.Hour),
+			PoolWithTrace(trace.PoolTrace{
+				ConnCreated: func(e trace.PoolConnCreated) {
+					l.Lock()
+					defer l.Unlock()
+					created = append(created, e.Reason)
+				},
+			}),
+		)
+		require.NoError(t, err)
+		defer pool.Close()
+
+		// simulate the connection being idle for longer than the sweep takes
+		// to notice
+		ioc, err := pool.get()
+		require.NoError(t, err)
+		ioc.lastUsedAt = ioc.lastUsedAt.Add(-2 * time.Hour)
+		pool.l.Lock()
+		pool.pool <- ioc
+		pool.l.Unlock()
+
+		// the expired connection is replaced by the refill, rather than by get
+		// itself
+		ioc2, err := pool.get()
+		require.NoError(t, err)
+		assert.False(t, ioc == ioc2)
+		pool.put(ioc2)
+		assert.Equal(t, uint64(1), pool.Stats().ConnsClosed)
+		l.Lock()
+		assert.Equal(t, []trace.PoolConnCreatedReason{
+			trace.PoolConnCreatedReasonInitialization,
+			trace.PoolConnCreatedReasonRefill,
+		}, created)
+		l.Unlock()
+	})
+}
+
+func TestPoolConnState(t *T) {
+	var l sync.Mutex
+	var cmds []string
+	var closed []trace.PoolConnClosedReason
+	connFunc := func(network, addr string) (Conn, error) {
+		return Stub(network, addr, func(args []string) interface{} {
+			l.Lock()
+			defer l.Unlock()
+			cmds = append(cmds, strings.Join(args, " "))
+			if args[0] == "ERR" {
+				return resp2.Error{E: errors.New("ERR stub")}
+			}
+			return "OK"
+		}), nil
+	}
+
+	pool, err := NewPool("tcp", "127.0.0.1:6379", 1,
+		PoolConnFunc(connFunc),
+		PoolOnEmptyCreateAfter(0),
+		PoolPingInterval(0),
+		PoolRefillInterval(0),
+		PoolPipelineWindow(0, 0),
+		PoolWithTrace(trace.PoolTrace{
+			ConnClosed: func(e trace.PoolConnClosed) {
+				l.Lock()
+				defer l.Unlock()
+				closed = append(closed, e.Reason)
+			},
+		}),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	for _, test := range []struct {
+		a       Action
+		expCmds []string
+		closed  bool
+	}{
+		{
+			a:       Cmd(nil, "SET", "foo", "bar"),
+			expCmds: []string{"SET foo bar"},
+		},
+		{
+			a: WithConn("", func(c Conn) error {
+				return c.Do(Cmd(nil, "MULTI"))
+			}),
+			expCmds: []string{"MULTI", "DISCARD"},
+		},
+		{
+			a: WithConn("", func(c Conn) error {
+				return c.Do(Pipeline(Cmd(nil, "MULTI"), Cmd(nil, "SET", "foo", "bar"), Cmd(nil, "EXEC")))
+			}),
+			expCmds: []string{"MULTI", "SET foo bar", "EXEC"},
+		},
+		{
+			a: WithConn("", func(c Conn) error {
+				return c.Do(Cmd(nil, "watch", "foo"))
+			}),
+			expCmds: []string{"watch foo", "UNWATCH"},
+		},
+		{
+			a: WithConn("", func(c Conn) error {
+				return c.Do(Cmd(nil, "SELECT", "1"))
+			}),
+			expCmds: []string{"SELECT 1"},
+			closed:  true,
+		},
+		{
+			a: WithConn("", func(c Conn) error {
+				return c.Do(FlatCmd(nil, "CLIENT", "REPLY", "OFF"))
+			}),
+			expCmds: []string{"CLIENT REPLY OFF"},
+			closed:  true,
+		},
+		{
+			a: WithConn("", func(c Conn) error {
+				return c.Do(pipelineableCmd{Cmd(nil, "MULTI"), "MULTI"})
+			}),
+			expCmds: []string{"MULTI", "DISCARD"},
+		},
+		{
+			// the commands of other resp.Marshalers aren't known, so they're
+			// assumed not to change the state
+			a: WithConn("", func(c Conn) error {
+				if err := c.Encode(resp2.Any{I: []string{"SET", "foo", "bar"}}); err != nil {
+					return err
+				}
+				return c.Decode(resp2.Any{})
+			}),
+			expCmds: []string{"SET foo bar"},
+		},
+		{
+			// the pipeline stops decoding replies after the first error
+			a:       Pipeline(Cmd(nil, "ERR"), Cmd(nil, "SET", "foo", "bar")),
+			expCmds: []string{"ERR", "SET foo bar"},
+			closed:  true,
+		},
+	} {
+		l.Lock()
+		cmds, closed = nil, nil
+		l.Unlock()
+
+		pool.Do(test.a)
+
+		l.Lock()
+		assert.Equal(t, test.expCmds, cmds)
+		if test.closed {
+			assert.Equal(t, []trace.PoolConnClosedReason{trace.PoolConnClosedReasonDirtyState}, closed)
+		} else {
+			assert.Empty(t, closed)
+		}
+		l.Unlock()
+
+		// a closed Conn is replaced right away
+		assert.Eventually(t, func() bool {
+			return pool.Stats().AvailConns == 1
+		}, time.Second, time.Millisecond)
+	}
+
+	// a Conn isn't reset if the Pool was closed while it was in use
+	l.Lock()
+	cmds, closed = nil, nil
+	l.Unlock()
+	assert.NoError(t, pool.Do(WithConn("", func(c Conn) error {
+		err := c.Do(Cmd(nil, "MULTI"))
+		assert.NoError(t, pool.Close())
+		return err
+	})))
+	l.Lock()
+	assert.Equal(t, []string{"MULTI"}, cmds)
+	assert.Equal(t, []trace.PoolConnClosedReason{trace.PoolConnClosedReasonPoolClosed}, closed)
+	l.Unlock()
+}
+
+func TestPoolSetSize(t *T) {
+	connFunc := func(network, addr string) (Conn, error) {
+		return Stub(network, addr, func(args []string) interface{} {
+			return args[0]
+		}), nil
+	}
+
+	var l sync.Mutex
+	var resized int
+	pool, err := NewPool("tcp", "127.0.0.1:6379", 2,
+		PoolConnFunc(connFunc),
+		PoolOnFullClose(),
+		PoolPingInterval(0),
+		PoolRefillInterval(time.Hour),
+		PoolWithTrace(trace.PoolTrace{
+			ConnClosed: func(e trace.PoolConnClosed) {
+				l.Lock()
+				defer l.Unlock()
+				if e.Reason == trace.PoolConnClosedReasonPoolResized {
+					resized++
+				}
+			},
+		}),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+	<-pool.initDone
+
+	require.NoError(t, pool.SetSize(4))
+	assert.Eventually(t, func() bool {
+		stats := pool.Stats()
+		return stats.Size == 4 && stats.TotalConns == 4 && stats.AvailConns == 4
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, pool.SetSize(1))
+	stats := pool.Stats()
+	assert.Equal(t, 1, stats.Size)
+	assert.Equal(t, 1, stats.TotalConns)
+	assert.Equal(t, 1, stats.AvailConns)
+	l.Lock()
+	assert.Equal(t, 3, resized)
+	l.Unlock()
+
+	assert.NoError(t, pool.Do(Cmd(nil, "PING")))
+	assert.Error(t, pool.SetSize(-1))
+
+	t.Run("waiting", func(t *T) {
+		pool, err := NewPool("tcp", "127.0.0.1:6379", 1,
+			PoolConnFunc(connFunc),
+			PoolOnEmptyWait(),
+			PoolPingInterval(0),
+			PoolRefillInterval(time.Hour),
+			PoolPipelineWindow(0, 0),
+		)
+		require.NoError(t, err)
+		defer pool.Close()
+
+		// one Action holds the only connection while another one waits for it
+		holdCh, releaseCh := make(chan struct{}), make(chan struct{})
+		go func() {
+			assert.NoError(t, pool.Do(WithConn("", func(Conn) error {
+				close(holdCh)
+				<-releaseCh
+				return nil
+			})))
+		}()
+		<-holdCh
+
+		waitCh := make(chan error, 1)
+		go func() { waitCh <- pool.Do(Cmd(nil, "PING")) }()
+		time.Sleep(50 * time.Millisecond) // give it time to start waiting
+
+		// resizing mustn't wait for the waiting Action, nor block the
+		// connection from being put back
+		resizeCh := make(chan error, 1)
+		go func() { resizeCh <- pool.SetSize(2) }()
+		select {
+		case err := <-resizeCh:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("SetSize blocked")
+		}
+
+		close(releaseCh)
+		select {
+		case err := <-waitCh:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("waiting Action never got a connection")
+		}
+	})
+}
+
+func TestPoolAdaptiveSize(t *T) {
+	connFunc := func(network, addr string) (Conn, error) {
+		return Stub(network, addr, func(args []string) interface{} {
+			return args[0]
+		}), nil
+	}
+
+	pool, err := NewPool("tcp", "127.0.0.1:6379", 5,
+		PoolConnFunc(connFunc),
+		PoolOnEmptyCreateAfter(0),
+		PoolOnFullBuffer(1, 10*time.Millisecond),
+		PoolPingInterval(0),
+		PoolRefillInterval(20*time.Millisecond),
+		PoolPipelineWindow(0, 0),
+		PoolAdaptiveSize(1, 3),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+	assert.Equal(t, 3, pool.Stats().Size)
+
+	// while connections are idle the pool shrinks down to min
+	assert.Eventually(t, func() bool {
+		stats := pool.Stats()
+		return stats.Size == 1 && stats.TotalConns == 1
+	}, time.Second, 5*time.Millisecond)
+
+	// while there are no available connections it grows up to max. One more
+	// connection than max is needed at once, since the overflow buffer holds
+	// one as well.
+	var nestedDo func(n int) error
+	nestedDo = func(n int) error {
+		return pool.Do(WithConn("", func(Conn) error {
+			if n--; n == 0 {
+				time.Sleep(time.Millisecond)
+				return nil
+			}
+			return nestedDo(n)
+		}))
+	}
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			nestedDo(4)
+		}
+	}()
+	assert.Eventually(t, func() bool {
+		return pool.Stats().Size == 3
+	}, time.Second, 5*time.Millisecond)
+	close(stopCh)
+	<-doneCh
+}
+
+// downConn fails all writes with a network error while down is set.
+type downConn struct {
+	Conn
+	down *int32
+}
+
+func (dc downConn) Encode(m resp.Marshaler) error {
+	if atomic.LoadInt32(dc.down) != 0 {
+		return &net.OpError{Op: "write", Net: "tcp", Err: errors.New("node is down")}
+	}
+	return dc.Conn.Encode(m)
+}
+
+func TestPoolCircuitBreaker(t *T) {
+	var down int32
+	connFunc := func(network, addr string) (Conn, error) {
+		if atomic.LoadInt32(&down) != 0 {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: errors.New("node is down")}
+		}
+		stub := Stub(network, addr, func(args []string) interface{} {
+			return args[0]
+		})
+		return downConn{Conn: stub, down: &down}, nil
+	}
+
+	var l sync.Mutex
+	var changes []bool
+	pool, err := NewPool("tcp", "127.0.0.1:6379", 2,
+		PoolConnFunc(connFunc),
+		PoolPingInterval(10*time.Millisecond),
+		PoolPipelineWindow(0, 0),
+		PoolCircuitBreaker(2),
+		PoolWithTrace(trace.PoolTrace{
+			CircuitChanged: func(cc trace.PoolCircuitChanged) {
+				l.Lock()
+				defer l.Unlock()
+				changes = append(changes, cc.Open)
+			},
+		}),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+	<-pool.initDone
+	require.NoError(t, pool.Do(Cmd(nil, "ECHO", "foo")))
+
+	// consecutive failures open the circuit, after which Actions fail fast
+	atomic.StoreInt32(&down, 1)
+	assert.Eventually(t, func() bool {
+		pool.Do(Cmd(nil, "ECHO", "foo"))
+		return pool.Stats().CircuitOpen
+	}, time.Second, time.Millisecond)
+
+	err = pool.Do(Cmd(nil, "ECHO", "foo"))
+	var coErr *CircuitOpenError
+	require.True(t, errors.As(err, &coErr), "err:%v", err)
+	assert.Equal(t, "127.0.0.1:6379", coErr.Addr)
+	assert.NotNil(t, coErr.Err)
+
+	stats := pool.Stats()
+	assert.Equal(t, 0, stats.AvailConns)
+	assert.Equal(t, uint64(1), stats.CircuitOpens)
+	assert.NotZero(t, stats.CircuitFastFails)
+
+	// probes keep failing while the node is down
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, pool.Stats().CircuitOpen)
+
+	// once the node is back a probe closes the circuit and the pool is refilled
+	atomic.StoreInt32(&down, 0)
+	assert.Eventually(t, func() bool {
+		stats := pool.Stats()
+		return !stats.CircuitOpen && stats.TotalConns == 2
+	}, time.Second, time.Millisecond)
+	assert.NoError(t, pool.Do(Cmd(nil, "ECHO", "foo")))
+
+	l.Lock()
+	defer l.Unlock()
+	assert.Equal(t, []bool{true, false}, changes)
+}
+
+func TestPoolCircuitBreakerInterleaved(t *T) {
+	var failPing int32
+	connFunc := func(network, addr string) (Conn, error) {
+		return Stub(network, addr, func(args []string) interface{} {
+			if args[0] == "PING" && atomic.LoadInt32(&failPing) != 0 {
+				return resp2.Error{E: errors.New("LOADING stub")}
+			}
+			return args[0]
+		}), nil
+	}
+
+	pool, err := NewPool("tcp", "127.0.0.1:6379", 5,
+		PoolConnFunc(connFunc),
+		PoolPingInterval(0),
+		PoolRefillInterval(0),
+		PoolPipelineWindow(0, 0),
+		PoolCircuitBreaker(3),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+	<-pool.initDone
+
+	ioErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("stub failure")}
+	get := func() *ioErrConn {
+		ioc, err := pool.get()
+		require.NoError(t, err)
+		return ioc
+	}
+
+	// a success of a connection retrieved before a failure doesn't reset the
+	// failures, so failures of concurrent Actions still open the circuit
+	a, b, c, d, e := get(), get(), get(), get(), get()
+	a.lastIOErr = ioErr
+	pool.put(a)
+	pool.put(b)
+	c.lastIOErr = ioErr
+	pool.put(c)
+	assert.False(t, pool.Stats().CircuitOpen)
+
+	d.lastIOErr = ioErr
+	pool.put(d)
+	assert.True(t, pool.Stats().CircuitOpen)
+
+	// neither is a success of a connection retrieved before the circuit
+	// opened, nor does a failed probe leave its connection in the pool
+	pool.put(e)
+	atomic.StoreInt32(&failPing, 1)
+	pool.doProbe()
+	stats := pool.Stats()
+	assert.True(t, stats.CircuitOpen)
+	assert.Equal(t, 0, stats.AvailConns)
+	assert.Equal(t, 0, stats.TotalConns)
+
+	atomic.StoreInt32(&failPing, 0)
+	pool.doProbe()
+	assert.False(t, pool.Stats().CircuitOpen)
+	assert.NoError(t, pool.Do(Cmd(nil, "ECHO", "foo")))
+}
+
+// pipelineableCmd is a custom PipelineableAction.
+type pipelineableCmd struct {
+	CmdAction
+	cmd string
+}
+
+func (pc pipelineableCmd) PipelineCmds() []string {
+	return []string{pc.cmd}
+}
+
+func TestPoolPipelineableActions(t *T) {
+	var l sync.Mutex
+	var evalCmds []string
+	var loaded bool
+	connFunc := func(network, addr string) (Conn, error) {
+		return Stub(network, addr, func(args []string) interface{} {
+			switch args[0] {
+			case "EVALSHA", "EVAL":
+				l.Lock()
+				defer l.Unlock()
+				evalCmds = append(evalCmds, args[0])
+				if args[0] == "EVAL" {
+					loaded = true
+				} else if !loaded {
+					return resp2.Error{E: errors.New("NOSCRIPT No matching script")}
+				}
+				return args[3]
+			default:
+				return args[len(args)-1]
+			}
+		}), nil
+	}
+
+	pool, err := NewPool("tcp", "127.0.0.1:6379", 1,
+		PoolConnFunc(connFunc),
+		PoolPingInterval(0),
+		PoolPipelineWindow(time.Millisecond, 0),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	script := NewEvalScript(1, "return KEYS[1]")
+	longPipeline := make([]CmdAction, pipelinerMaxCmds+1)
+	for i := range longPipeline {
+		longPipeline[i] = Cmd(nil, "ECHO", "foo")
+	}
+
+	canDoTests := []struct {
+		descr string
+		a     Action
+		exp   bool
+	}{
+		{"Cmd", Cmd(nil, "GET", "foo"), true},
+		{"FlatCmd", FlatCmd(nil, "SET", "foo", 1), true},
+		{"blocking Cmd", Cmd(nil, "blpop", "foo", "0"), false},
+		{"EvalScript.Cmd", script.Cmd(nil, "foo"), true},
+		{"custom", pipelineableCmd{Cmd(nil, "GET", "foo"), "GET"}, true},
+		{"custom blocking", pipelineableCmd{Cmd(nil, "BLPOP", "foo", "0"), "BLPOP"}, false},
+		{"custom without PipelineCmds", struct{ CmdAction }{Cmd(nil, "GET", "foo")}, false},
+		{"Pipeline", Pipeline(Cmd(nil, "GET", "foo"), script.Cmd(nil, "foo").(CmdAction)), true},
+		{"Pipeline with blocking Cmd", Pipeline(Cmd(nil, "GET", "foo"), Cmd(nil, "BLPOP", "foo", "0")), false},
+		{"long Pipeline", Pipeline(longPipeline...), false},
+		{"empty Pipeline", Pipeline(), false},
+		{"ctxCmdAction", ctxCmdAction{CmdAction: Cmd(nil, "GET", "foo")}, false},
+		{"WithConn", WithConn("", func(Conn) error { return nil }), false},
+	}
+	for _, test := range canDoTests {
+		assert.Equal(t, test.exp, pool.pipeliner.CanDo(test.a), test.descr)
+	}
+
+	// all of them are actually pipelined, and their responses read correctly
+	var wg sync.WaitGroup
+	var custom, pipeA, pipeB, eval string
+	for _, a := range []Action{
+		pipelineableCmd{Cmd(&custom, "ECHO", "custom"), "ECHO"},
+		Pipeline(Cmd(&pipeA, "ECHO", "a"), Cmd(&pipeB, "ECHO", "b")),
+		script.Cmd(&eval, "eval"),
+	} {
+		wg.Add(1)
+		go func(a Action) {
+			defer wg.Done()
+			assert.NoError(t, pool.Do(a))
+		}(a)
+	}
+	wg.Wait()
+
+	assert.Equal(t, "custom", custom)
+	assert.Equal(t, "a", pipeA)
+	assert.Equal(t, "b", pipeB)
+	assert.Equal(t, "eval", eval)
+	assert.NotZero(t, pool.Stats().PipelineCmds)
+
+	// the NOSCRIPT error caused the script to be performed again on its own,
+	// using EVAL right away
+	l.Lock()
+	defer l.Unlock()
+	assert.Equal(t, []string{"EVALSHA", "EVAL"}, evalCmds)
+}
+
+func TestPoolPipelineAdaptiveWindow(t *T) {
+	connFunc := func(network, addr string) (Conn, error) {
+		return Stub(network, addr, func(args []string) interface{} {
+			return args[len(args)-1]
+		}), nil
+	}
+
+	pool, err := NewPool("tcp", "127.0.0.1:6379", 2,
+		PoolConnFunc(connFunc),
+		PoolPingInterval(0),
+		PoolPipelineAdaptiveWindow(time.Hour, 0),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	// a single Action is flushed right away, rather than after the window
+	var out string
+	start := time.Now()
+	require.NoError(t, pool.Do(Cmd(&out, "ECHO", "foo")))
+	assert.Equal(t, "foo", out)
+	assert.True(t, time.Since(start) < time.Second, "took %v", time.Since(start))
+
+	// concurrent Actions are pipelined together
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				var out string
+				assert.NoError(t, pool.Do(Cmd(&out, "ECHO", "bar")))
+				assert.Equal(t, "bar", out)
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats := pool.Stats()
+	assert.Equal(t, uint64(1001), stats.PipelineCmds)
+	assert.True(t, stats.PipelineFlushes < stats.PipelineCmds, "stats:%+v", stats)
+
+	var numFlushes uint64
+	for _, n := range stats.PipelineBatchSizes {
+		numFlushes += n
+	}
+	assert.Equal(t, stats.PipelineFlushes, numFlushes)
+	assert.NotEqual(t, stats.PipelineFlushes, stats.PipelineBatchSizes[0])
+}
+
+func TestPipelineBatchSizeIndex(t *T) {
+	for numCmds, exp := range map[int]int{
+		1: 0, 2: 1, 3: 2, 4: 2, 5: 3, 8: 3, 9: 4, 64: 6, 65: 7, 128: 7, 1000: 7,
+	} {
+		assert.Equal(t, exp, pipelineBatchSizeIndex(numCmds), "numCmds:%d", numCmds)
+	}
+}
+
+type poolDeadlineAction struct {
+	Action
+	deadline time.Time
+}
+
+func (a poolDeadlineAction) PoolDeadline() time.Time {
+	return a.deadline
+}
+
+func TestPoolFIFOWait(t *T) {
+	connFunc := func(network, addr string) (Conn, error) {
+		return Stub(network, addr, func(args []string) interface{} {
+			return args[0]
+		}), nil
+	}
+	newPool := func(opts ...PoolOpt) *Pool {
+		opts = append([]PoolOpt{
+			PoolConnFunc(connFunc),
+			PoolOnFullClose(),
+			PoolPingInterval(0),
+			PoolPipelineWindow(0, 0),
+		}, opts...)
+		pool, err := NewPool("tcp", "127.0.0.1:6379", 1, opts...)
+		require.NoError(t, err)
+		return pool
+	}
+
+	// hold takes the only connection of the pool until the returned function
+	// is called
+	hold := func(pool *Pool) func() {
+		heldCh, releaseCh, doneCh := make(chan struct{}), make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(doneCh)
+			assert.NoError(t, pool.Do(WithConn("", func(Conn) error {
+				close(heldCh)
+				<-releaseCh
+				return nil
+			})))
+		}()
+		<-heldCh
+		return func() {
+			close(releaseCh)
+			<-doneCh
+		}
+	}
+
+	waitQueueLen := func(pool *Pool, n int) {
+		assert.Eventually(t, func() bool {
+			return pool.Stats().WaitQueueLen == n
+		}, time.Second, time.Millisecond)
+	}
+
+	t.Run("order", func(t *T) {
+		pool := newPool(PoolOnEmptyWait(), PoolFIFOWait(2))
+		defer pool.Close()
+		release := hold(pool)
+
+		var l sync.Mutex
+		var order []string
+		var wg sync.WaitGroup
+		for i, name := range []string{"a", "b"} {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				assert.NoError(t, pool.Do(WithConn("", func(Conn) error {
+					l.Lock()
+					defer l.Unlock()
+					order = append(order, name)
+					return nil
+				})))
+			}(name)
+			waitQueueLen(pool, i+1)
+		}
+
+		// the queue is full
+		assert.Equal(t, ErrPoolQueueFull, pool.Do(Cmd(nil, "PING")))
+
+		release()
+		wg.Wait()
+		assert.Equal(t, []string{"a", "b"}, order)
+
+		stats := pool.Stats()
+		assert.Equal(t, 0, stats.WaitQueueLen)
+		assert.Equal(t, 2, stats.WaitQueuePeak)
+		assert.Equal(t, uint64(1), stats.WaitQueueRejections)
+		assert.Equal(t, 1, stats.TotalConns)
+	})
+
+	t.Run("timeout", func(t *T) {
+		pool := newPool(PoolOnEmptyErrAfter(20*time.Millisecond), PoolFIFOWait(0))
+		defer pool.Close()
+		release := hold(pool)
+		defer release()
+
+		start := time.Now()
+		assert.Equal(t, ErrPoolEmpty, pool.Do(Cmd(nil, "PING")))
+		assert.True(t, time.Since(start) >= 20*time.Millisecond)
+		assert.Equal(t, 0, pool.Stats().WaitQueueLen)
+	})
+
+	t.Run("deadline", func(t *T) {
+		pool := newPool(PoolOnEmptyWait(), PoolFIFOWait(0))
+		defer pool.Close()
+		release := hold(pool)
+		defer release()
+
+		start := time.Now()
+		a := poolDeadlineAction{
+			Action:   Cmd(nil, "PING"),
+			deadline: start.Add(20 * time.Millisecond),
+		}
+		assert.Equal(t, ErrPoolEmpty, pool.Do(a))
+		assert.True(t, time.Since(start) >= 20*time.Millisecond)
+		assert.Equal(t, 0, pool.Stats().WaitQueueLen)
+
+		// a deadline which already passed doesn't wait at all
+		a.deadline = start
+		assert.Equal(t, ErrPoolEmpty, pool.Do(a))
+		assert.Equal(t, uint64(2), pool.Stats().EmptyTimeouts)
+	})
+
+	t.Run("close", func(t *T) {
+		pool := newPool(PoolOnEmptyWait(), PoolFIFOWait(0))
+		release := hold(pool)
+		defer release()
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- pool.Do(Cmd(nil, "PING")) }()
+		waitQueueLen(pool, 1)
+
+		require.NoError(t, pool.Close())
+		assert.Equal(t, errClientClosed, <-errCh)
+	})
+}
+
+func TestPoolBlockingConns(t *T) {
+	// each command is recorded along with the index of the conn it was
+	// performed on, the first conn being the one of the normal connections
+	var l sync.Mutex
+	var numConns int
+	var cmds []string
+	connFunc := func(network, addr string) (Conn, error) {
+		l.Lock()
+		i := numConns
+		numConns++
+		l.Unlock()
+		return Stub(network, addr, func(args []string) interface{} {
+			l.Lock()
+			defer l.Unlock()
+			cmds = append(cmds, strings.Join(append([]string{strconv.Itoa(i)}, args...), " "))
+			return args[len(args)-1]
+		}), nil
+	}
+
+	pool, err := NewPool("tcp", "127.0.0.1:6379", 1,
+		PoolConnFunc(connFunc),
+		PoolPingInterval(0),
+		PoolBlockingConns(1, PoolPingInterval(0)),
+	)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	for _, a := range []Action{
+		Cmd(nil, "GET", "foo"),
+		Cmd(nil, "BLPOP", "foo", "1"),
+		Cmd(nil, "XREAD", "STREAMS", "BLOCK", "0"),
+		Cmd(nil, "XREAD", "BLOCK", "100", "STREAMS", "foo", "0"),
+		Pipeline(Cmd(nil, "GET", "foo"), Cmd(nil, "WAIT", "1", "0")),
+		LongRunning(WithConn("foo", func(conn Conn) error {
+			return conn.Do(Cmd(nil, "ECHO", "foo"))
+		}), time.Second),
+	} {
+		require.NoError(t, pool.Do(a))
+	}
+
+	assert.Equal(t, []string{
+		"0 GET foo",
+		"1 BLPOP foo 1",
+		"0 XREAD STREAMS BLOCK 0",
+		"1 XREAD BLOCK 100 STREAMS foo 0",
+		"1 GET foo",
+		"1 WAIT 1 0",
+		"1 ECHO foo",
+	}, cmds)
+
+	stats := pool.Stats()
+	assert.Equal(t, 1, stats.TotalConns)
+	require.NotNil(t, stats.Blocking)
+	assert.Equal(t, 1, stats.Blocking.TotalConns)
+	assert.Equal(t, uint64(4), stats.Blocking.Gets)
+}
+
+func TestBlockingTimeout(t *T) {
+	tests := []struct {
+		a        Action
+		exp      time.Duration
+		blocking bool
+	}{
+		{Cmd(nil, "GET", "foo"), 0, false},
+		{Cmd(nil, "blpop", "foo", "bar", "2"), 2 * time.Second, true},
+		{FlatCmd(nil, "BZPOPMIN", "foo", 0.5), 500 * time.Millisecond, true},
+		{Cmd(nil, "BRPOP", "foo", "0"), 0, true},
+		{Cmd(nil, "WAIT", "1", "250"), 250 * time.Millisecond, true},
+		{Cmd(nil, "XREAD", "COUNT", "1", "STREAMS", "foo", "0"), 0, false},
+		{Cmd(nil, "XREAD", "block", "10", "STREAMS", "foo", "0"), 10 * time.Millisecond, true},
+		{Cmd(nil, "XREADGROUP", "GROUP", "g", "c", "BLOCK", "0", "STREAMS", "foo", ">"), 0, true},
+		{Cmd(nil, "SAVE"), 0, true},
+		{ctxCmdAction{CmdAction: Cmd(nil, "BLPOP", "foo", "3")}, 3 * time.Second, true},
+		{Pipeline(Cmd(nil, "GET", "foo"), Cmd(nil, "BLPOP", "foo", "1"), Cmd(nil, "WAIT", "1", "500")), 1500 * time.Millisecond, true},
+		{Pipeline(Cmd(nil, "BLPOP", "foo", "1"), Cmd(nil, "BLPOP", "foo", "0")), 0, true},
+		{pipelineableCmd{Cmd(nil, "BLPOP", "foo", "1"), "BLPOP"}, 0, true},
+		{LongRunning(Cmd(nil, "GET", "foo"), time.Minute), time.Minute, true},
+		{WithConn("foo", func(Conn) error { return nil }), 0, false},
+	}
+	for _, test := range tests {
+		d, blocking := blockingTimeout(test.a)
+		assert.Equal(t, test.blocking, blocking, "action:%v", test.a)
+		assert.Equal(t, test.exp, d, "action:%v", test.a)
+	}
+
+	// the read timeout of connections created by Dial is extended, and
+	// restored afterwards
+	netConn, _ := net.Pipe()
+	tc := &timeoutConn{Conn: netConn, readTimeout: time.Second}
+	conn := newIOErrConn(NewConn(tc))
+	defer conn.Close()
+
+	restore := extendReadTimeoutUnder(conn, 2*time.Second)
+	assert.Equal(t, 3*time.Second, tc.readTimeout)
+	restore()
+	assert.Equal(t, time.Second, tc.readTimeout)
+
+	restore = extendReadTimeoutUnder(conn, 0)
+	assert.Zero(t, tc.readTimeout)
+	restore()
+	assert.Equal(t, time.Second, tc.readTimeout)
+}
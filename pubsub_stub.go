@@ -173,6 +173,13 @@ func (s *pubSubStub) spin() {
 				}
 			}
 			if err := s.Conn.Encode(m); err != nil {
+				// the Conn may have been closed while the message was being
+				// received, in which case the message is simply dropped
+				select {
+				case <-s.closeCh:
+					return
+				default:
+				}
 				panic(fmt.Sprintf("error encoding message in PubSubStub: %s", err))
 			}
 			select {
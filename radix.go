@@ -131,6 +131,7 @@ package radix
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"net"
 	"net/url"
@@ -161,10 +162,13 @@ type Client interface {
 // redis instance on the given network/address.
 type ClientFunc func(network, addr string) (Client, error)
 
+// defaultPoolSize is the size of the Pools created by DefaultClientFunc.
+const defaultPoolSize = 20
+
 // DefaultClientFunc is a ClientFunc which will return a Client for a redis
 // instance using sane defaults.
 var DefaultClientFunc = func(network, addr string) (Client, error) {
-	return NewPool(network, addr, 20)
+	return NewPool(network, addr, defaultPoolSize)
 }
 
 // Conn is a Client wrapping a single network connection which synchronously
@@ -265,8 +269,9 @@ var DefaultConnFunc = func(network, addr string) (Conn, error) {
 
 type dialOpts struct {
 	connectTimeout, readTimeout, writeTimeout time.Duration
-	authPass                                  string
+	authUser, authPass                        string
 	selectDB                                  string
+	tlsConfig                                 *tls.Config
 }
 
 // DialOpt is an optional behavior which can be applied to the Dial function to
@@ -318,6 +323,29 @@ func DialAuthPass(pass string) DialOpt {
 	}
 }
 
+// DialAuthUser will cause Dial to perform an AUTH command once the connection
+// is created, using the given user and pass. This is used for authenticating
+// as an ACL user, which is supported since Redis 6. If user is empty this is
+// the same as DialAuthPass.
+//
+// If this is set and a redis URI is passed to Dial which also has a password
+// set, this takes precedence.
+func DialAuthUser(user, pass string) DialOpt {
+	return func(do *dialOpts) {
+		do.authUser = user
+		do.authPass = pass
+	}
+}
+
+// DialUseTLS will cause Dial to perform a TLS handshake using the given config
+// once the connection is created. If config doesn't have a ServerName set, the
+// host of the dialed address is used.
+func DialUseTLS(config *tls.Config) DialOpt {
+	return func(do *dialOpts) {
+		do.tlsConfig = config
+	}
+}
+
 // DialSelectDB will cause Dial to perform a SELECT command once the connection
 // is created, using the given database index.
 //
@@ -341,6 +369,37 @@ func (tc *timeoutConn) Read(b []byte) (int, error) {
 	return tc.Conn.Read(b)
 }
 
+// extendReadTimeoutUnder extends the read timeout of the connection underlying
+// any Conn wrappers of this package by d, or removes it if d is zero, and
+// returns a function which restores the previous read timeout. If the Conn
+// wasn't created by Dial its read timeout can't be changed, and the returned
+// function does nothing.
+func extendReadTimeoutUnder(conn Conn, d time.Duration) func() {
+	for {
+		switch c := conn.(type) {
+		case *ioErrConn:
+			conn = c.Conn
+		case askConn:
+			conn = c.Conn
+		case *connWrap:
+			tc, ok := c.Conn.(*timeoutConn)
+			if !ok || tc.readTimeout == 0 {
+				return func() {}
+			}
+			prev := tc.readTimeout
+			if d > 0 {
+				tc.readTimeout += d
+			} else {
+				tc.readTimeout = 0
+				tc.Conn.SetReadDeadline(time.Time{})
+			}
+			return func() { tc.readTimeout = prev }
+		default:
+			return func() {}
+		}
+	}
+}
+
 func (tc *timeoutConn) Write(b []byte) (int, error) {
 	if tc.writeTimeout > 0 {
 		tc.Conn.SetWriteDeadline(time.Now().Add(tc.writeTimeout))
@@ -358,10 +417,11 @@ var defaultDialOpts = []DialOpt{
 // In place of a host:port address, Dial also accepts a URI, as per:
 // 	https://www.iana.org/assignments/uri-schemes/prov/redis
 // If the URI has an AUTH password or db specified Dial will attempt to perform
-// the AUTH and/or SELECT as well.
+// the AUTH and/or SELECT as well. If the URI uses the "rediss" scheme Dial will
+// use TLS, using the config given by DialUseTLS if any.
 //
-// If either DialAuthPass or DialSelectDB is used it overwrites the associated
-// value passed in by the URI.
+// If either DialAuthPass, DialAuthUser or DialSelectDB is used it overwrites
+// the associated value passed in by the URI.
 //
 // The default options Dial uses are:
 //
@@ -378,9 +438,12 @@ func Dial(network, addr string, opts ...DialOpt) (Conn, error) {
 
 	// do a quick check before we bust out url.Parse, in case that is very
 	// unperformant
-	if strings.HasPrefix(addr, "redis://") {
+	if strings.HasPrefix(addr, "redis://") || strings.HasPrefix(addr, "rediss://") {
 		if u, err := url.Parse(addr); err == nil {
 			addr = u.Host
+			if u.Scheme == "rediss" && do.tlsConfig == nil {
+				do.tlsConfig = new(tls.Config)
+			}
 			q := u.Query()
 			if do.authPass == "" {
 				if p, ok := u.User.Password(); ok {
@@ -431,13 +494,24 @@ func Dial(network, addr string, opts ...DialOpt) (Conn, error) {
 		}
 	}
 
+	if do.tlsConfig != nil {
+		if netConn, err = dialTLS(netConn, addr, do); err != nil {
+			return nil, err
+		}
+	}
+
 	conn := NewConn(&timeoutConn{
 		readTimeout:  do.readTimeout,
 		writeTimeout: do.writeTimeout,
 		Conn:         netConn,
 	})
 
-	if do.authPass != "" {
+	if do.authUser != "" {
+		if err := conn.Do(Cmd(nil, "AUTH", do.authUser, do.authPass)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	} else if do.authPass != "" {
 		if err := conn.Do(Cmd(nil, "AUTH", do.authPass)); err != nil {
 			conn.Close()
 			return nil, err
@@ -453,3 +527,33 @@ func Dial(network, addr string, opts ...DialOpt) (Conn, error) {
 
 	return conn, nil
 }
+
+// dialTLS wraps netConn in a TLS client connection and performs the handshake,
+// closing netConn if that fails.
+func dialTLS(netConn net.Conn, addr string, do dialOpts) (net.Conn, error) {
+	config := do.tlsConfig
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			netConn.Close()
+			return nil, err
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+
+	if do.connectTimeout > 0 {
+		netConn.SetDeadline(time.Now().Add(do.connectTimeout))
+	}
+
+	tlsConn := tls.Client(netConn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	if do.connectTimeout > 0 {
+		netConn.SetDeadline(time.Time{})
+	}
+	return tlsConn, nil
+}
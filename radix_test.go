@@ -2,7 +2,11 @@ package radix
 
 import (
 	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
 	"regexp"
 	"strings"
 	. "testing"
@@ -69,6 +73,22 @@ func TestDialAuth(t *T) {
 	}
 }
 
+func TestDialTLS(t *T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	certPool := x509.NewCertPool()
+	certPool.AddCert(srv.Certificate())
+
+	// the handshake is done by Dial, so a successful Dial means TLS is used
+	c, err := Dial("tcp", srv.Listener.Addr().String(), DialUseTLS(&tls.Config{RootCAs: certPool}))
+	require.NoError(t, err)
+	c.Close()
+
+	_, err = Dial("tcp", srv.Listener.Addr().String(), DialUseTLS(&tls.Config{}))
+	assert.Error(t, err)
+}
+
 func TestDialSelect(t *T) {
 
 	// unfortunately this is the best way to discover the currently selected
@@ -1,15 +1,28 @@
 package radix
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/vikram-suki/radix/v3/resp/resp2"
 )
 
 type sentinelOpts struct {
 	cf ConnFunc
 	pf ClientFunc
+
+	sentinelDialOpts, nodeDialOpts []DialOpt
+
+	replicaMaxLag   int64
+	primaryQuorum   int
+	failoverMaxWait time.Duration
+	noRoleCheck     bool
 }
 
 // SentinelOpt is an optional behavior which can be applied to the NewSentinel
@@ -32,6 +45,85 @@ func SentinelPoolFunc(pf ClientFunc) SentinelOpt {
 	}
 }
 
+// SentinelDialOpts tells the Sentinel to connect to sentinel instances using
+// Dial with the given options, e.g. for authentication or TLS. Multiple calls
+// add to the options given previously.
+//
+// This overwrites any SentinelConnFunc given before, and is overwritten by any
+// SentinelConnFunc given after.
+func SentinelDialOpts(opts ...DialOpt) SentinelOpt {
+	return func(so *sentinelOpts) {
+		so.sentinelDialOpts = append(so.sentinelDialOpts, opts...)
+		so.cf = func(network, addr string) (Conn, error) {
+			return Dial(network, addr, so.sentinelDialOpts...)
+		}
+	}
+}
+
+// SentinelNodeDialOpts tells the Sentinel to create pools to the primary and
+// its replicas using DefaultClientFunc's settings, but with connections created
+// using Dial with the given options, e.g. for authentication or TLS. Multiple
+// calls add to the options given previously.
+//
+// This overwrites any SentinelPoolFunc given before, and is overwritten by any
+// SentinelPoolFunc given after.
+func SentinelNodeDialOpts(opts ...DialOpt) SentinelOpt {
+	return func(so *sentinelOpts) {
+		so.nodeDialOpts = append(so.nodeDialOpts, opts...)
+		so.pf = func(network, addr string) (Client, error) {
+			return NewPool(network, addr, defaultPoolSize, PoolConnFunc(func(network, addr string) (Conn, error) {
+				return Dial(network, addr, so.nodeDialOpts...)
+			}))
+		}
+	}
+}
+
+// SentinelAuth tells the Sentinel to authenticate to sentinel instances with
+// the given user and pass. user may be empty if sentinels don't use ACL users.
+// See SentinelDialOpts and DialAuthUser.
+func SentinelAuth(user, pass string) SentinelOpt {
+	return SentinelDialOpts(DialAuthUser(user, pass))
+}
+
+// SentinelNodeAuth tells the Sentinel to authenticate to the primary and its
+// replicas with the given user and pass. user may be empty if ACL users aren't
+// used. See SentinelNodeDialOpts and DialAuthUser.
+func SentinelNodeAuth(user, pass string) SentinelOpt {
+	return SentinelNodeDialOpts(DialAuthUser(user, pass))
+}
+
+// SentinelTLS tells the Sentinel to connect to sentinel instances using TLS
+// with the given config. See SentinelDialOpts and DialUseTLS.
+func SentinelTLS(config *tls.Config) SentinelOpt {
+	return SentinelDialOpts(DialUseTLS(config))
+}
+
+// SentinelNodeTLS tells the Sentinel to connect to the primary and its replicas
+// using TLS with the given config. See SentinelNodeDialOpts and DialUseTLS.
+func SentinelNodeTLS(config *tls.Config) SentinelOpt {
+	return SentinelNodeDialOpts(DialUseTLS(config))
+}
+
+// SentinelPrimaryQuorum tells the Sentinel to only switch to a new primary once
+// at least quorum of the known sentinels report the same primary address.
+// Sentinels which can't be reached count as disagreeing.
+//
+// By default only the sentinel the Sentinel is currently connected to is asked.
+func SentinelPrimaryQuorum(quorum int) SentinelOpt {
+	return func(so *sentinelOpts) {
+		so.primaryQuorum = quorum
+	}
+}
+
+// SentinelNoRoleCheck tells the Sentinel to trust the primary advertised by the
+// sentinels without checking its role using ROLE, e.g. if the instances are
+// behind a proxy which doesn't forward ROLE to them.
+func SentinelNoRoleCheck() SentinelOpt {
+	return func(so *sentinelOpts) {
+		so.noRoleCheck = true
+	}
+}
+
 // Sentinel is a Client which, in the background, connects to an available
 // sentinel node and handles all of the following:
 //
@@ -44,6 +136,13 @@ func SentinelPoolFunc(pf ClientFunc) SentinelOpt {
 // * Keeps track of other sentinels in the cluster, and uses them if the
 // currently connected one becomes unreachable
 //
+// * Verifies that the primary advertised by the sentinel actually has the
+// primary role (using ROLE) before switching to it, unless SentinelNoRoleCheck
+// is used, and re-checks the primary whenever a command fails with a READONLY
+// error
+//
+// To track multiple primaries monitored by the same sentinels, use a
+// SentinelManager instead of multiple Sentinels.
 type Sentinel struct {
 	so        sentinelOpts
 	initAddrs []string
@@ -54,13 +153,39 @@ type Sentinel struct {
 	l             sync.RWMutex
 	primAddr      string
 	clients       map[string]Client
+	primChangedCh chan struct{} // closed and replaced whenever primAddr changes
+
 	sentinelAddrs map[string]bool // the known sentinel addresses
 
+	// replicasCmd is the SENTINEL subcommand used to retrieve the replicas. It
+	// is only accessed by ensureClients, which is never called concurrently.
+	replicasCmd string
+
+	// readAddrs are the addresses of the healthy secondaries, as used by
+	// ReadClient. readCounter is only accessed atomically.
+	readAddrs   []string
+	readCounter uint32
+
 	// We use a persistent PubSubConn here, so we don't need to do much after
 	// initialization. The pconn is only really kept around for closing
 	pconn   PubSubConn
 	pconnCh chan PubSubMessage
 
+	// manager is set if the Sentinel was created by a SentinelManager, in which
+	// case pconn and requeryCh are shared with it and it runs the spin instead.
+	manager *SentinelManager
+
+	// requeryCh is written to when the primary should be re-checked, e.g.
+	// because it returned a READONLY error.
+	requeryCh chan struct{}
+
+	// eventsMsgCh is only subscribed to once the first channel is subscribed
+	// to events using SubscribeEvents.
+	eventsL      sync.Mutex
+	eventsSubbed bool
+	eventsMsgCh  chan PubSubMessage
+	eventChs     map[chan<- SentinelEvent]bool
+
 	// Any errors encountered internally will be written to this channel. If
 	// nothing is reading the channel the errors will be dropped. The channel
 	// will be closed when the Close is called.
@@ -82,35 +207,15 @@ type Sentinel struct {
 //	SentinelConnFunc(DefaultConnFunc)
 //	SentinelPoolFunc(DefaultClientFunc)
 //
+// Before switching to a primary the Sentinel checks that it reports itself as
+// primary using ROLE. If ROLE isn't allowed, e.g. by the ACL rules of the user
+// or because it was renamed, the sentinels' answer is used as is. The check
+// can be disabled using SentinelNoRoleCheck.
+//
 func NewSentinel(primaryName string, sentinelAddrs []string, opts ...SentinelOpt) (*Sentinel, error) {
-	addrs := map[string]bool{}
-	for _, addr := range sentinelAddrs {
-		addrs[addr] = true
-	}
-
-	sc := &Sentinel{
-		initAddrs:     sentinelAddrs,
-		name:          primaryName,
-		sentinelAddrs: addrs,
-		pconnCh:       make(chan PubSubMessage, 1),
-		ErrCh:         make(chan error, 1),
-		closeCh:       make(chan bool),
-		testEventCh:   make(chan string, 1),
-	}
-
-	defaultSentinelOpts := []SentinelOpt{
-		SentinelConnFunc(DefaultConnFunc),
-		SentinelPoolFunc(DefaultClientFunc),
-	}
-
-	for _, opt := range append(defaultSentinelOpts, opts...) {
-		// the other args to NewSentinel used to be a ConnFunc and a ClientFunc,
-		// which someone might have left as nil, in which case this now gives a
-		// weird panic. Just handle it
-		if opt != nil {
-			opt(&(sc.so))
-		}
-	}
+	sc := newSentinel(primaryName, sentinelAddrs, applySentinelOpts(opts))
+	sc.pconnCh = make(chan PubSubMessage, 1)
+	sc.requeryCh = make(chan struct{}, 1)
 
 	// first thing is to retrieve the state and create a pool using the first
 	// connectable connection. This connection is only used during
@@ -124,7 +229,7 @@ func NewSentinel(primaryName string, sentinelAddrs []string, opts ...SentinelOpt
 
 		if err := sc.ensureSentinelAddrs(conn); err != nil {
 			return nil, err
-		} else if err := sc.ensureClients(conn); err != nil {
+		} else if err := sc.ensureClients(conn, false); err != nil {
 			return nil, err
 		}
 	}
@@ -133,13 +238,174 @@ func NewSentinel(primaryName string, sentinelAddrs []string, opts ...SentinelOpt
 	sc.pconn = PersistentPubSub("", "", func(_, _ string) (Conn, error) {
 		return sc.dialSentinel()
 	})
-	sc.pconn.Subscribe(sc.pconnCh, "switch-master")
+	sc.pconn.Subscribe(sc.pconnCh, sentinelSwitchMasterChannels...)
 
-	sc.closeWG.Add(1)
+	sc.closeWG.Add(2)
 	go sc.spin()
+	go sc.eventsSpin()
 	return sc, nil
 }
 
+// sentinelSwitchMasterChannels are the channels which are subscribed to in
+// order to be notified of failovers. Sentinels publish the event on
+// "+switch-master", "switch-master" is kept for compatibility.
+var sentinelSwitchMasterChannels = []string{"switch-master", "+switch-master"}
+
+func applySentinelOpts(opts []SentinelOpt) sentinelOpts {
+	var so sentinelOpts
+	defaultSentinelOpts := []SentinelOpt{
+		SentinelConnFunc(DefaultConnFunc),
+		SentinelPoolFunc(DefaultClientFunc),
+	}
+
+	for _, opt := range append(defaultSentinelOpts, opts...) {
+		// the other args to NewSentinel used to be a ConnFunc and a ClientFunc,
+		// which someone might have left as nil, in which case this now gives a
+		// weird panic. Just handle it
+		if opt != nil {
+			opt(&so)
+		}
+	}
+	return so
+}
+
+// newSentinel returns a Sentinel without any state or background goroutines.
+// The pconn, pconnCh and requeryCh fields must be set by the caller.
+func newSentinel(primaryName string, sentinelAddrs []string, so sentinelOpts) *Sentinel {
+	addrs := map[string]bool{}
+	for _, addr := range sentinelAddrs {
+		addrs[addr] = true
+	}
+
+	return &Sentinel{
+		so:            so,
+		initAddrs:     sentinelAddrs,
+		name:          primaryName,
+		sentinelAddrs: addrs,
+		primChangedCh: make(chan struct{}),
+		replicasCmd:   "REPLICAS",
+		eventsMsgCh:   make(chan PubSubMessage, 1),
+		eventChs:      map[chan<- SentinelEvent]bool{},
+		ErrCh:         make(chan error, 1),
+		closeCh:       make(chan bool),
+		testEventCh:   make(chan string, 1),
+	}
+}
+
+// NewSentinelFromURI is like NewSentinel, but takes the addresses of the
+// sentinels, the name of the primary and other settings from a URI of the
+// format:
+//
+//	redis-sentinel://[[user]:pass@]host[:port][,host[:port]...]/name[/db][?options]
+//
+// user and pass are used to authenticate to the primary and its replicas, as
+// is db for selecting the database on them. If port is omitted it is 26379.
+// The options "sentinel_user" and "sentinel_password" can be used to
+// authenticate to the sentinels.
+//
+// If the scheme is "rediss-sentinel" both sentinels and data nodes are
+// connected to using TLS with a default config.
+//
+// The given opts are applied after the settings from the URI, and so take
+// precedence over them.
+func NewSentinelFromURI(uri string, opts ...SentinelOpt) (*Sentinel, error) {
+	name, addrs, uriOpts, err := parseSentinelURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	return NewSentinel(name, addrs, append(uriOpts, opts...)...)
+}
+
+func parseSentinelURI(uri string) (string, []string, []SentinelOpt, error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("invalid sentinel URI %q: %s", uri, reason)
+	}
+
+	// url.Parse can't be used, since it doesn't allow multiple hosts
+	split := strings.SplitN(uri, "://", 2)
+	if len(split) != 2 {
+		return "", nil, nil, invalid("missing scheme")
+	}
+	scheme, rest := split[0], split[1]
+
+	var opts []SentinelOpt
+	switch scheme {
+	case "redis-sentinel":
+	case "rediss-sentinel":
+		opts = append(opts, SentinelTLS(new(tls.Config)), SentinelNodeTLS(new(tls.Config)))
+	default:
+		return "", nil, nil, invalid("unknown scheme")
+	}
+
+	var query url.Values
+	if i := strings.IndexByte(rest, '?'); i >= 0 {
+		var err error
+		if query, err = url.ParseQuery(rest[i+1:]); err != nil {
+			return "", nil, nil, invalid(err.Error())
+		}
+		rest = rest[:i]
+	}
+
+	authority, path := rest, ""
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		authority, path = rest[:i], rest[i+1:]
+	}
+
+	var nodeDialOpts []DialOpt
+	if i := strings.LastIndexByte(authority, '@'); i >= 0 {
+		userInfo := authority[:i]
+		authority = authority[i+1:]
+
+		user, pass := userInfo, ""
+		if j := strings.IndexByte(userInfo, ':'); j >= 0 {
+			user, pass = userInfo[:j], userInfo[j+1:]
+		}
+		var err error
+		if user, err = url.PathUnescape(user); err != nil {
+			return "", nil, nil, invalid(err.Error())
+		} else if pass, err = url.PathUnescape(pass); err != nil {
+			return "", nil, nil, invalid(err.Error())
+		}
+		nodeDialOpts = append(nodeDialOpts, DialAuthUser(user, pass))
+	}
+
+	var addrs []string
+	for _, host := range strings.Split(authority, ",") {
+		if host == "" {
+			continue
+		} else if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), "26379")
+		}
+		addrs = append(addrs, host)
+	}
+	if len(addrs) == 0 {
+		return "", nil, nil, invalid("no sentinel addresses")
+	}
+
+	pathParts := strings.Split(path, "/")
+	name := pathParts[0]
+	if name == "" {
+		return "", nil, nil, invalid("no primary name")
+	} else if len(pathParts) > 2 {
+		return "", nil, nil, invalid("too many path segments")
+	} else if len(pathParts) == 2 && pathParts[1] != "" {
+		db, err := strconv.Atoi(pathParts[1])
+		if err != nil {
+			return "", nil, nil, invalid("invalid db")
+		}
+		nodeDialOpts = append(nodeDialOpts, DialSelectDB(db))
+	}
+
+	if len(nodeDialOpts) > 0 {
+		opts = append(opts, SentinelNodeDialOpts(nodeDialOpts...))
+	}
+	if pass := query.Get("sentinel_password"); pass != "" {
+		opts = append(opts, SentinelAuth(query.Get("sentinel_user"), pass))
+	}
+
+	return name, addrs, opts, nil
+}
+
 func (sc *Sentinel) err(err error) {
 	select {
 	case sc.ErrCh <- err:
@@ -154,6 +420,13 @@ func (sc *Sentinel) testEvent(event string) {
 	}
 }
 
+func (sc *Sentinel) requery() {
+	select {
+	case sc.requeryCh <- struct{}{}:
+	default:
+	}
+}
+
 func (sc *Sentinel) dialSentinel() (Conn, error) {
 	sc.l.RLock()
 	defer sc.l.RUnlock()
@@ -183,11 +456,40 @@ func (sc *Sentinel) dialSentinel() (Conn, error) {
 //
 // NOTE it's possible that in between Do being called and the Action being
 // actually carried out that there could be a failover event. In that case, the
-// Action will likely fail and return an error.
+// Action will likely fail and return an error. If the error is a READONLY
+// error, meaning the primary has been demoted, the Sentinel will re-check the
+// primary in the background. If SentinelFailoverRetry is used and the Action
+// is marked as retryable the Sentinel will instead wait for the new primary
+// and perform the Action on it.
 func (sc *Sentinel) Do(a Action) error {
-	sc.l.RLock()
-	defer sc.l.RUnlock()
-	return sc.clients[sc.primAddr].Do(a)
+	var timer *time.Timer
+	for {
+		sc.l.RLock()
+		primChangedCh := sc.primChangedCh
+		err := sc.clients[sc.primAddr].Do(a)
+		sc.l.RUnlock()
+
+		if err != nil && strings.HasPrefix(err.Error(), "READONLY") {
+			sc.requery()
+		}
+
+		if !isSentinelFailoverErr(err) || sc.so.failoverMaxWait <= 0 || !isRetryableAction(a) {
+			return err
+		} else if timer == nil {
+			// the max wait applies to all retries together
+			timer = time.NewTimer(sc.so.failoverMaxWait)
+			defer timer.Stop()
+		}
+
+		select {
+		case <-primChangedCh:
+			// retry on the new primary
+		case <-timer.C:
+			return err
+		case <-sc.closeCh:
+			return err
+		}
+	}
 }
 
 // Addrs returns the currently known network address of the current primary
@@ -252,13 +554,19 @@ func (sc *Sentinel) Client(addr string) (Client, error) {
 
 // Close implements the method for the Client interface.
 func (sc *Sentinel) Close() error {
-	sc.l.Lock()
-	defer sc.l.Unlock()
 	closeErr := errClientClosed
 	sc.closeOnce.Do(func() {
+		if sc.manager != nil {
+			sc.manager.remove(sc)
+		}
 		close(sc.closeCh)
+		// the background goroutine may need the lock before it notices the
+		// closeCh, so it must not be held while waiting for it
 		sc.closeWG.Wait()
 		closeErr = nil
+
+		sc.l.Lock()
+		defer sc.l.Unlock()
 		for _, client := range sc.clients {
 			if client != nil {
 				client.Close()
@@ -277,14 +585,21 @@ func sentinelMtoAddr(m map[string]string, cmd string) (string, error) {
 }
 
 // given a connection to a sentinel, ensures that the Clients currently being
-// held agrees with what the sentinel thinks they should be
-func (sc *Sentinel) ensureClients(conn Conn) error {
+// held agrees with what the sentinel thinks they should be. The role of the
+// primary is verified if it changed or if verifyPrim is true.
+func (sc *Sentinel) ensureClients(conn Conn, verifyPrim bool) error {
 	var primM map[string]string
 	var secMM []map[string]string
-	if err := conn.Do(Pipeline(
+	err := conn.Do(Pipeline(
 		Cmd(&primM, "SENTINEL", "MASTER", sc.name),
-		Cmd(&secMM, "SENTINEL", "SLAVES", sc.name),
-	)); err != nil {
+		Cmd(&secMM, "SENTINEL", sc.replicasCmd, sc.name),
+	))
+	if isUnknownSentinelCmdErr(err) && sc.replicasCmd != "SLAVES" {
+		// REPLICAS was only added in Redis 5, fall back to the deprecated
+		// SLAVES for older sentinels
+		sc.replicasCmd = "SLAVES"
+		return sc.ensureClients(conn, verifyPrim)
+	} else if err != nil {
 		return err
 	}
 
@@ -295,18 +610,32 @@ func (sc *Sentinel) ensureClients(conn Conn) error {
 
 	newClients := map[string]Client{newPrimAddr: nil}
 	for _, secM := range secMM {
-		newSecAddr, err := sentinelMtoAddr(secM, "SENTINEL SLAVES")
+		newSecAddr, err := sentinelMtoAddr(secM, "SENTINEL "+sc.replicasCmd)
 		if err != nil {
 			return err
 		}
 		newClients[newSecAddr] = nil
 	}
 
-	return sc.setClients(newPrimAddr, newClients)
+	sc.l.RLock()
+	primChanged := sc.primAddr != newPrimAddr
+	sc.l.RUnlock()
+
+	if primChanged {
+		if err := sc.ensurePrimaryQuorum(newPrimAddr); err != nil {
+			return err
+		}
+	}
+
+	readAddrs := sentinelReadAddrs(secMM, sc.so.replicaMaxLag)
+	return sc.setClients(newPrimAddr, newClients, readAddrs, verifyPrim || primChanged)
 }
 
-// all values of newClients should be nil
-func (sc *Sentinel) setClients(newPrimAddr string, newClients map[string]Client) error {
+// all values of newClients should be nil. readAddrs is applied together with
+// newClients, so that reads are never routed to an address which isn't a
+// replica anymore. If verifyPrim is true the role of the primary is verified
+// before the new state is applied, even if it didn't change.
+func (sc *Sentinel) setClients(newPrimAddr string, newClients map[string]Client, readAddrs []string, verifyPrim bool) error {
 	newClients[newPrimAddr] = nil
 	var toClose []Client
 
@@ -342,24 +671,52 @@ func (sc *Sentinel) setClients(newPrimAddr string, newClients map[string]Client)
 	}
 
 	sc.l.RUnlock()
-	if !stateChanged {
+	if !stateChanged && !verifyPrim {
+		// the health or lag of the replicas may have changed nonetheless
+		sc.l.Lock()
+		sc.readAddrs = readAddrs
+		sc.l.Unlock()
 		return nil
 	}
 
 	// if the primary doesn't have a client created, create it here outside the
 	// lock where it won't block everything else
+	var newPrimClient Client
 	if newClients[newPrimAddr] == nil {
 		var err error
-		if newClients[newPrimAddr], err = sc.so.pf("tcp", newPrimAddr); err != nil {
+		if newPrimClient, err = sc.so.pf("tcp", newPrimAddr); err != nil {
+			return err
+		}
+		newClients[newPrimAddr] = newPrimClient
+	}
+
+	if verifyPrim && !sc.so.noRoleCheck {
+		if err := sentinelVerifyPrimary(newPrimAddr, newClients[newPrimAddr]); err != nil {
+			if newPrimClient != nil {
+				newPrimClient.Close()
+			}
 			return err
 		}
 	}
 
 	sc.l.Lock()
+	var events []SentinelEvent
+	if sc.primAddr != "" {
+		events = sentinelStateEvents(sc.name, sc.primAddr, sc.clients, newPrimAddr, newClients)
+	}
+	if sc.primAddr != newPrimAddr {
+		close(sc.primChangedCh)
+		sc.primChangedCh = make(chan struct{})
+	}
 	sc.primAddr = newPrimAddr
 	sc.clients = newClients
+	sc.readAddrs = readAddrs
 	sc.l.Unlock()
 
+	for _, e := range events {
+		sc.publishEvent(e)
+	}
+
 	for _, client := range toClose {
 		client.Close()
 	}
@@ -367,6 +724,86 @@ func (sc *Sentinel) setClients(newPrimAddr string, newClients map[string]Client)
 	return nil
 }
 
+// sentinelVerifyPrimary returns an error unless the instance at addr, which
+// client is connected to, reports itself as primary using ROLE. This protects
+// against writing to a demoted primary advertised by a stale sentinel. If ROLE
+// isn't allowed on the instance it's assumed to be the primary.
+func sentinelVerifyPrimary(addr string, client Client) error {
+	var rms []resp2.RawMessage
+	if err := client.Do(Cmd(&rms, "ROLE")); isSentinelRoleDenied(err) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if len(rms) == 0 {
+		return fmt.Errorf("malformed ROLE response from %s", addr)
+	}
+
+	var role string
+	if err := rms[0].UnmarshalInto(resp2.Any{I: &role}); err != nil {
+		return err
+	} else if role != "master" {
+		return fmt.Errorf("primary %s advertised by sentinel has role %q", addr, role)
+	}
+	return nil
+}
+
+// isSentinelRoleDenied returns whether err means that ROLE can't be used, either
+// because the ACL rules don't allow it or because it was renamed.
+func isSentinelRoleDenied(err error) bool {
+	rerr, ok := err.(resp2.Error)
+	if !ok {
+		return false
+	}
+	msg := rerr.Error()
+	return strings.HasPrefix(msg, "NOPERM") || strings.HasPrefix(msg, "ERR unknown command")
+}
+
+// ensurePrimaryQuorum returns an error unless at least the configured quorum
+// of known sentinels report addr as the address of the primary.
+func (sc *Sentinel) ensurePrimaryQuorum(addr string) error {
+	if sc.so.primaryQuorum <= 1 {
+		return nil
+	}
+
+	sc.l.RLock()
+	sentAddrs := make([]string, 0, len(sc.sentinelAddrs))
+	for sentAddr := range sc.sentinelAddrs {
+		sentAddrs = append(sentAddrs, sentAddr)
+	}
+	sc.l.RUnlock()
+
+	var agree int
+	for _, sentAddr := range sentAddrs {
+		if primAddr, err := sc.sentinelPrimaryAddr(sentAddr); err == nil && primAddr == addr {
+			agree++
+		}
+	}
+
+	if agree < sc.so.primaryQuorum {
+		return fmt.Errorf("only %d of %d sentinels agree on primary %s, quorum is %d",
+			agree, len(sentAddrs), addr, sc.so.primaryQuorum)
+	}
+	return nil
+}
+
+// sentinelPrimaryAddr asks the sentinel at sentAddr for the address of the
+// primary.
+func (sc *Sentinel) sentinelPrimaryAddr(sentAddr string) (string, error) {
+	conn, err := sc.so.cf("tcp", sentAddr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	var ipPort []string
+	if err := conn.Do(Cmd(&ipPort, "SENTINEL", "GET-MASTER-ADDR-BY-NAME", sc.name)); err != nil {
+		return "", err
+	} else if len(ipPort) != 2 {
+		return "", fmt.Errorf("malformed SENTINEL GET-MASTER-ADDR-BY-NAME response")
+	}
+	return net.JoinHostPort(ipPort[0], ipPort[1]), nil
+}
+
 // annoyingly the SENTINEL SENTINELS <name> command doesn't return _this_
 // sentinel instance, only the others it knows about for that primary
 func (sc *Sentinel) ensureSentinelAddrs(conn Conn) error {
@@ -415,6 +852,8 @@ func (sc *Sentinel) spin() {
 // * Periodically re-ensuring that the list of sentinel addresses is up-to-date
 // * Periodically re-checking the current primary, in case the switch-master was
 //   missed somehow
+// * Re-checking the role of the current primary when requested through
+//   requeryCh
 func (sc *Sentinel) innerSpin() error {
 	conn, err := sc.dialSentinel()
 	if err != nil {
@@ -425,21 +864,25 @@ func (sc *Sentinel) innerSpin() error {
 	tick := time.NewTicker(5 * time.Second)
 	defer tick.Stop()
 
-	var switchMaster bool
+	var switchMaster, verifyPrim bool
 	for {
 		if err := sc.ensureSentinelAddrs(conn); err != nil {
 			return err
-		} else if err := sc.ensureClients(conn); err != nil {
+		} else if err := sc.ensureClients(conn, verifyPrim); err != nil {
 			return err
 		}
 		sc.pconn.Ping()
 
 		// the tests want to know when the client state has been updated due to
-		// a switch-master event
+		// a switch-master event or a re-check of the primary
 		if switchMaster {
 			sc.testEvent("switch-master completed")
 			switchMaster = false
 		}
+		if verifyPrim {
+			sc.testEvent("primary verified")
+			verifyPrim = false
+		}
 
 		select {
 		case <-tick.C:
@@ -447,6 +890,9 @@ func (sc *Sentinel) innerSpin() error {
 		case <-sc.pconnCh:
 			switchMaster = true
 			// loop
+		case <-sc.requeryCh:
+			verifyPrim = true
+			// loop
 		case <-sc.closeCh:
 			return nil
 		}
@@ -0,0 +1,342 @@
+package radix
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vikram-suki/radix/v3/resp"
+	"github.com/vikram-suki/radix/v3/resp/resp2"
+)
+
+// sentinelInfoParser parses the fields of an instance as returned by SENTINEL
+// MASTER, REPLICAS and SENTINELS. Only the first parse error is kept, and
+// missing fields are left at their zero value.
+type sentinelInfoParser struct {
+	m   map[string]string
+	err error
+}
+
+func (p *sentinelInfoParser) int64(key string) int64 {
+	s, ok := p.m[key]
+	if !ok || p.err != nil {
+		return 0
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		p.err = err
+	}
+	return i
+}
+
+func (p *sentinelInfoParser) int(key string) int {
+	return int(p.int64(key))
+}
+
+func (p *sentinelInfoParser) ms(key string) time.Duration {
+	return time.Duration(p.int64(key)) * time.Millisecond
+}
+
+func (p *sentinelInfoParser) addr(hostKey, portKey string) string {
+	if p.m[hostKey] == "" || p.m[portKey] == "" {
+		return ""
+	}
+	return net.JoinHostPort(p.m[hostKey], p.m[portKey])
+}
+
+// unmarshalSentinelInfo reads a single instance as returned by SENTINEL
+// MASTER, REPLICAS and SENTINELS, and parses its common fields into info.
+func unmarshalSentinelInfo(br *bufio.Reader, info *SentinelInstanceInfo) (*sentinelInfoParser, error) {
+	var m map[string]string
+	if err := (resp2.Any{I: &m}).UnmarshalRESP(br); err != nil {
+		return nil, err
+	}
+
+	p := &sentinelInfoParser{m: m}
+	*info = SentinelInstanceInfo{
+		Name:            m["name"],
+		Addr:            p.addr("ip", "port"),
+		RunID:           m["runid"],
+		LastOKPingReply: p.ms("last-ok-ping-reply"),
+		Fields:          m,
+	}
+	if m["flags"] != "" {
+		info.Flags = strings.Split(m["flags"], ",")
+	}
+	return p, nil
+}
+
+// SentinelInstanceInfo contains the fields common to all instances as returned
+// by the SENTINEL MASTER, REPLICAS and SENTINELS commands.
+type SentinelInstanceInfo struct {
+	Name  string
+	Addr  string
+	RunID string
+
+	// Flags contains the flags of the instance, e.g. "master", "s_down" or
+	// "disconnected".
+	Flags []string
+
+	// LastOKPingReply is the time since the instance last replied to a PING
+	// successfully.
+	LastOKPingReply time.Duration
+
+	// Fields contains all fields as returned by the sentinel, including those
+	// not parsed into other fields.
+	Fields map[string]string
+}
+
+// HasFlag returns whether the instance has the given flag.
+func (i SentinelInstanceInfo) HasFlag(flag string) bool {
+	for _, f := range i.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// SentinelPrimaryInfo describes a primary as returned by SENTINEL MASTER.
+type SentinelPrimaryInfo struct {
+	SentinelInstanceInfo
+
+	ConfigEpoch       int64
+	NumReplicas       int
+	NumOtherSentinels int
+	Quorum            int
+	FailoverTimeout   time.Duration
+	ParallelSyncs     int
+}
+
+var _ resp.Unmarshaler = (*SentinelPrimaryInfo)(nil)
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (i *SentinelPrimaryInfo) UnmarshalRESP(br *bufio.Reader) error {
+	p, err := unmarshalSentinelInfo(br, &i.SentinelInstanceInfo)
+	if err != nil {
+		return err
+	}
+	i.ConfigEpoch = p.int64("config-epoch")
+	i.NumReplicas = p.int("num-slaves")
+	i.NumOtherSentinels = p.int("num-other-sentinels")
+	i.Quorum = p.int("quorum")
+	i.FailoverTimeout = p.ms("failover-timeout")
+	i.ParallelSyncs = p.int("parallel-syncs")
+	return p.err
+}
+
+// SentinelReplicaInfo describes a replica as returned by SENTINEL REPLICAS.
+type SentinelReplicaInfo struct {
+	SentinelInstanceInfo
+
+	// PrimaryAddr is the address of the primary as reported by the replica.
+	PrimaryAddr string
+
+	// PrimaryLinkStatus is "ok" if the replica is connected to its primary, or
+	// "err" otherwise.
+	PrimaryLinkStatus   string
+	PrimaryLinkDownTime time.Duration
+
+	Priority   int
+	ReplOffset int64
+}
+
+var _ resp.Unmarshaler = (*SentinelReplicaInfo)(nil)
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (i *SentinelReplicaInfo) UnmarshalRESP(br *bufio.Reader) error {
+	p, err := unmarshalSentinelInfo(br, &i.SentinelInstanceInfo)
+	if err != nil {
+		return err
+	}
+	i.PrimaryAddr = p.addr("master-host", "master-port")
+	i.PrimaryLinkStatus = p.m["master-link-status"]
+	i.PrimaryLinkDownTime = p.ms("master-link-down-time")
+	i.Priority = p.int("slave-priority")
+	i.ReplOffset = p.int64("slave-repl-offset")
+	return p.err
+}
+
+// SentinelSentinelInfo describes a sentinel as returned by SENTINEL SENTINELS.
+type SentinelSentinelInfo struct {
+	SentinelInstanceInfo
+
+	// LastHelloMessage is the time since the last hello message was received
+	// from the sentinel.
+	LastHelloMessage time.Duration
+
+	VotedLeader      string
+	VotedLeaderEpoch int64
+}
+
+var _ resp.Unmarshaler = (*SentinelSentinelInfo)(nil)
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (i *SentinelSentinelInfo) UnmarshalRESP(br *bufio.Reader) error {
+	p, err := unmarshalSentinelInfo(br, &i.SentinelInstanceInfo)
+	if err != nil {
+		return err
+	}
+	i.LastHelloMessage = p.ms("last-hello-message")
+	i.VotedLeader = p.m["voted-leader"]
+	i.VotedLeaderEpoch = p.int64("voted-leader-epoch")
+	return p.err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// isUnknownSentinelCmdErr returns whether err was caused by a SENTINEL
+// subcommand not being supported by the server, e.g. REPLICAS before Redis 5.
+func isUnknownSentinelCmdErr(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "unknown sentinel subcommand")
+}
+
+// The following functions take a Client which must be connected to a sentinel,
+// e.g. a Conn created using DefaultConnFunc with the address of a sentinel.
+
+// SentinelPrimary returns information about the primary with the given name
+// using SENTINEL MASTER.
+func SentinelPrimary(c Client, name string) (SentinelPrimaryInfo, error) {
+	var info SentinelPrimaryInfo
+	err := c.Do(Cmd(&info, "SENTINEL", "MASTER", name))
+	return info, err
+}
+
+// SentinelReplicas returns information about the replicas of the primary with
+// the given name using SENTINEL REPLICAS, or SENTINEL SLAVES if the sentinel
+// doesn't support REPLICAS.
+func SentinelReplicas(c Client, name string) ([]SentinelReplicaInfo, error) {
+	var replicas []SentinelReplicaInfo
+	err := c.Do(Cmd(&replicas, "SENTINEL", "REPLICAS", name))
+	if isUnknownSentinelCmdErr(err) {
+		replicas = nil
+		err = c.Do(Cmd(&replicas, "SENTINEL", "SLAVES", name))
+	}
+	return replicas, err
+}
+
+// SentinelSentinels returns information about the other sentinels monitoring
+// the primary with the given name using SENTINEL SENTINELS.
+func SentinelSentinels(c Client, name string) ([]SentinelSentinelInfo, error) {
+	var sentinels []SentinelSentinelInfo
+	err := c.Do(Cmd(&sentinels, "SENTINEL", "SENTINELS", name))
+	return sentinels, err
+}
+
+// SentinelFailover forces a failover of the primary with the given name using
+// SENTINEL FAILOVER, without asking other sentinels for agreement.
+func SentinelFailover(c Client, name string) error {
+	return c.Do(Cmd(nil, "SENTINEL", "FAILOVER", name))
+}
+
+// SentinelCKQuorum checks whether the sentinels monitoring the primary with the
+// given name can reach the quorum needed for a failover, and the majority
+// needed to authorize it, using SENTINEL CKQUORUM. If they can't, the returned
+// error describes why.
+func SentinelCKQuorum(c Client, name string) error {
+	return c.Do(Cmd(nil, "SENTINEL", "CKQUORUM", name))
+}
+
+// SentinelReset resets all primaries with names matching the glob-style
+// pattern using SENTINEL RESET, and returns the number of primaries reset.
+func SentinelReset(c Client, pattern string) (int, error) {
+	var n int
+	err := c.Do(Cmd(&n, "SENTINEL", "RESET", pattern))
+	return n, err
+}
+
+// SentinelMonitor tells the sentinel to start monitoring the primary at addr
+// under the given name using SENTINEL MONITOR.
+func SentinelMonitor(c Client, name, addr string, quorum int) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	return c.Do(Cmd(nil, "SENTINEL", "MONITOR", name, host, port, strconv.Itoa(quorum)))
+}
+
+// SentinelRemove tells the sentinel to stop monitoring the primary with the
+// given name using SENTINEL REMOVE.
+func SentinelRemove(c Client, name string) error {
+	return c.Do(Cmd(nil, "SENTINEL", "REMOVE", name))
+}
+
+// SentinelSet changes the configuration of the sentinel for the primary with
+// the given name, e.g. "down-after-milliseconds", using SENTINEL SET.
+func SentinelSet(c Client, name string, options map[string]string) error {
+	args := make([]string, 0, 2+len(options)*2)
+	args = append(args, "SET", name)
+	for k, v := range options {
+		args = append(args, k, v)
+	}
+	return c.Do(Cmd(nil, "SENTINEL", args...))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// withSentinelConn calls fn with a new connection to one of the known
+// sentinels, which is closed once fn returns.
+func (sc *Sentinel) withSentinelConn(fn func(Conn) error) error {
+	conn, err := sc.dialSentinel()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return fn(conn)
+}
+
+// Primary returns information about the primary, as seen by one of the known
+// sentinels. See SentinelPrimary.
+func (sc *Sentinel) Primary() (SentinelPrimaryInfo, error) {
+	var info SentinelPrimaryInfo
+	err := sc.withSentinelConn(func(conn Conn) error {
+		var err error
+		info, err = SentinelPrimary(conn, sc.name)
+		return err
+	})
+	return info, err
+}
+
+// Replicas returns information about the replicas of the primary, as seen by
+// one of the known sentinels. See SentinelReplicas.
+func (sc *Sentinel) Replicas() ([]SentinelReplicaInfo, error) {
+	var replicas []SentinelReplicaInfo
+	err := sc.withSentinelConn(func(conn Conn) error {
+		var err error
+		replicas, err = SentinelReplicas(conn, sc.name)
+		return err
+	})
+	return replicas, err
+}
+
+// Sentinels returns information about the sentinels monitoring the primary,
+// other than the one which is asked. See SentinelSentinels.
+func (sc *Sentinel) Sentinels() ([]SentinelSentinelInfo, error) {
+	var sentinels []SentinelSentinelInfo
+	err := sc.withSentinelConn(func(conn Conn) error {
+		var err error
+		sentinels, err = SentinelSentinels(conn, sc.name)
+		return err
+	})
+	return sentinels, err
+}
+
+// Failover forces a failover of the primary using one of the known sentinels.
+// See SentinelFailover.
+//
+// The Sentinel will switch to the new primary once the failover completes.
+func (sc *Sentinel) Failover() error {
+	return sc.withSentinelConn(func(conn Conn) error {
+		return SentinelFailover(conn, sc.name)
+	})
+}
+
+// CKQuorum checks whether the sentinels monitoring the primary can reach the
+// quorum needed for a failover. See SentinelCKQuorum.
+func (sc *Sentinel) CKQuorum() error {
+	return sc.withSentinelConn(func(conn Conn) error {
+		return SentinelCKQuorum(conn, sc.name)
+	})
+}
@@ -0,0 +1,186 @@
+package radix
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+// Types of SentinelEvents which aren't published by the sentinels, but
+// generated by the Sentinel itself when the state of its Clients changes.
+const (
+	// SentinelEventPrimaryChanged is generated when the Sentinel switched to a
+	// new primary, whose address is in Addr.
+	SentinelEventPrimaryChanged = "client-primary-changed"
+
+	// SentinelEventReplicaAdded is generated when the Sentinel learned about a
+	// new replica, whose address is in Addr.
+	SentinelEventReplicaAdded = "client-replica-added"
+
+	// SentinelEventReplicaRemoved is generated when a replica, whose address is
+	// in Addr, isn't known to the Sentinel anymore.
+	SentinelEventReplicaRemoved = "client-replica-removed"
+)
+
+// SentinelEvent describes an event published by a sentinel, such as "+sdown",
+// "-odown", "+failover-state-select-slave", "+slave" or "+sentinel", or a change
+// in the state of a Sentinel's Clients (see the SentinelEvent* constants).
+type SentinelEvent struct {
+	// Type is the type of the event, which for events published by a sentinel
+	// is the channel it was published on, e.g. "+sdown".
+	Type string
+
+	// InstanceType, Name and Addr describe the instance the event is about.
+	// InstanceType is one of "master", "slave" or "sentinel".
+	//
+	// For events generated by the Sentinel itself InstanceType is "master" or
+	// "slave" and Name is empty.
+	InstanceType string
+	Name         string
+	Addr         string
+
+	// PrimaryName and PrimaryAddr describe the primary the instance belongs
+	// to. If the instance is a primary they are the same as Name and Addr.
+	PrimaryName string
+	PrimaryAddr string
+
+	// Message is the raw message published by the sentinel. It is empty for
+	// events generated by the Sentinel itself.
+	Message string
+}
+
+// parseSentinelEvent parses an event published by a sentinel. Most events have
+// the format:
+//
+//	<instance-type> <name> <ip> <port> @ <master-name> <master-ip> <master-port>
+//
+// where the part starting with @ is omitted if the instance is a master.
+// Messages which don't have this format, except for +switch-master, are only
+// available through the Message field.
+func parseSentinelEvent(m PubSubMessage) SentinelEvent {
+	e := SentinelEvent{Type: m.Channel, Message: string(m.Message)}
+	fields := strings.Fields(e.Message)
+
+	// +switch-master <master name> <oldip> <oldport> <newip> <newport>
+	if e.Type == "+switch-master" {
+		if len(fields) == 5 {
+			e.InstanceType, e.Name = "master", fields[0]
+			e.Addr = net.JoinHostPort(fields[3], fields[4])
+			e.PrimaryName, e.PrimaryAddr = e.Name, e.Addr
+		}
+		return e
+	}
+
+	if len(fields) < 4 {
+		return e
+	}
+	e.InstanceType, e.Name = fields[0], fields[1]
+	e.Addr = net.JoinHostPort(fields[2], fields[3])
+
+	if len(fields) >= 8 && fields[4] == "@" {
+		e.PrimaryName = fields[5]
+		e.PrimaryAddr = net.JoinHostPort(fields[6], fields[7])
+	} else if e.InstanceType == "master" {
+		e.PrimaryName, e.PrimaryAddr = e.Name, e.Addr
+	}
+	return e
+}
+
+// sentinelStateEvents returns the events describing the change from the old to
+// the new primary and replicas.
+func sentinelStateEvents(
+	name, oldPrimAddr string, oldClients map[string]Client,
+	newPrimAddr string, newClients map[string]Client,
+) []SentinelEvent {
+	var events []SentinelEvent
+	event := func(typ, instanceType, addr string) {
+		events = append(events, SentinelEvent{
+			Type:         typ,
+			InstanceType: instanceType,
+			Addr:         addr,
+			PrimaryName:  name,
+			PrimaryAddr:  newPrimAddr,
+		})
+	}
+
+	if oldPrimAddr != newPrimAddr {
+		event(SentinelEventPrimaryChanged, "master", newPrimAddr)
+	}
+
+	var added, removed []string
+	for addr := range newClients {
+		if _, ok := oldClients[addr]; (!ok || addr == oldPrimAddr) && addr != newPrimAddr {
+			added = append(added, addr)
+		}
+	}
+	for addr := range oldClients {
+		if _, ok := newClients[addr]; (!ok || addr == newPrimAddr) && addr != oldPrimAddr {
+			removed = append(removed, addr)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	for _, addr := range added {
+		event(SentinelEventReplicaAdded, "slave", addr)
+	}
+	for _, addr := range removed {
+		event(SentinelEventReplicaRemoved, "slave", addr)
+	}
+	return events
+}
+
+// SubscribeEvents subscribes ch to all events published by the sentinels about
+// the primary the Sentinel is managing and its replicas and sentinels, as well
+// as to changes of the primary and replicas used by the Sentinel.
+//
+// Events are written to ch without blocking, so if ch isn't buffered or isn't
+// read from fast enough events will be dropped.
+//
+// Events published by sentinels are only received once SubscribeEvents has
+// been called for the first time.
+func (sc *Sentinel) SubscribeEvents(ch chan<- SentinelEvent) {
+	sc.eventsL.Lock()
+	defer sc.eventsL.Unlock()
+
+	if !sc.eventsSubbed {
+		// all sentinel events start with either "+" or "-"
+		sc.pconn.PSubscribe(sc.eventsMsgCh, "+*", "-*")
+		sc.eventsSubbed = true
+	}
+	sc.eventChs[ch] = true
+}
+
+// UnsubscribeEvents unsubscribes ch from events, if it was subscribed.
+func (sc *Sentinel) UnsubscribeEvents(ch chan<- SentinelEvent) {
+	sc.eventsL.Lock()
+	defer sc.eventsL.Unlock()
+	delete(sc.eventChs, ch)
+}
+
+func (sc *Sentinel) publishEvent(e SentinelEvent) {
+	sc.eventsL.Lock()
+	defer sc.eventsL.Unlock()
+	for ch := range sc.eventChs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (sc *Sentinel) eventsSpin() {
+	defer sc.closeWG.Done()
+	for {
+		select {
+		case m := <-sc.eventsMsgCh:
+			// sentinels may monitor multiple primaries, only the events about
+			// our own are of interest
+			if e := parseSentinelEvent(m); e.PrimaryName == sc.name {
+				sc.publishEvent(e)
+			}
+		case <-sc.closeCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package radix
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SentinelManager tracks any number of primaries monitored by the same set of
+// sentinels, sharing a single connection to a sentinel and a single pubsub
+// subscription between all of them. For each primary name a Sentinel is handed
+// out which behaves like one created by NewSentinel.
+//
+// In the background the SentinelManager handles all of the following for every
+// primary it tracks:
+//
+// * Listens for switch-master events and updates the Clients of the affected
+// primary
+//
+// * Periodically re-checks the primary and its replicas, and the sentinels
+// monitoring it, in case an event was missed
+//
+// * Re-checks the role of a primary whenever a command on it fails with a
+// READONLY error
+//
+type SentinelManager struct {
+	so        sentinelOpts
+	initAddrs []string
+
+	// l is read locked while the state of the Sentinels is being updated, so
+	// that a Sentinel can't be removed while it's in use
+	l         sync.RWMutex
+	sentinels map[string]*Sentinel
+	closed    bool
+
+	// pconn and requeryCh are shared by all Sentinels
+	pconn     PubSubConn
+	pconnCh   chan PubSubMessage
+	requeryCh chan struct{}
+
+	// Any errors encountered internally which aren't specific to a single
+	// primary will be written to this channel. If nothing is reading the
+	// channel the errors will be dropped. Errors specific to a primary are
+	// written to the ErrCh of its Sentinel.
+	ErrCh chan error
+
+	closeCh   chan bool
+	closeWG   sync.WaitGroup
+	closeOnce sync.Once
+
+	// only used by tests to ensure certain actions have happened before
+	// continuing on during the test
+	testEventCh chan string
+}
+
+// NewSentinelManager creates and returns a *SentinelManager which connects to
+// the given sentinels. It takes the same options as NewSentinel, which apply to
+// all Sentinels it hands out.
+//
+// No primaries are tracked initially, use the Sentinel method to start tracking
+// one.
+func NewSentinelManager(sentinelAddrs []string, opts ...SentinelOpt) (*SentinelManager, error) {
+	sm := &SentinelManager{
+		so:          applySentinelOpts(opts),
+		initAddrs:   sentinelAddrs,
+		sentinels:   map[string]*Sentinel{},
+		pconnCh:     make(chan PubSubMessage, 1),
+		requeryCh:   make(chan struct{}, 1),
+		ErrCh:       make(chan error, 1),
+		closeCh:     make(chan bool),
+		testEventCh: make(chan string, 1),
+	}
+
+	// make sure at least one sentinel is reachable, like NewSentinel does
+	conn, err := sm.dialSentinel()
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+
+	// because we're using persistent these can't _really_ fail
+	sm.pconn = PersistentPubSub("", "", func(_, _ string) (Conn, error) {
+		return sm.dialSentinel()
+	})
+	sm.pconn.Subscribe(sm.pconnCh, sentinelSwitchMasterChannels...)
+
+	sm.closeWG.Add(1)
+	go sm.spin()
+	return sm, nil
+}
+
+func (sm *SentinelManager) err(err error) {
+	select {
+	case sm.ErrCh <- err:
+	default:
+	}
+}
+
+func (sm *SentinelManager) testEvent(event string) {
+	select {
+	case sm.testEventCh <- event:
+	default:
+	}
+}
+
+// sortedSentinels returns all tracked Sentinels sorted by primary name. sm.l
+// must be held.
+func (sm *SentinelManager) sortedSentinels() []*Sentinel {
+	scs := make([]*Sentinel, 0, len(sm.sentinels))
+	for _, sc := range sm.sentinels {
+		scs = append(scs, sc)
+	}
+	sort.Slice(scs, func(i, j int) bool { return scs[i].name < scs[j].name })
+	return scs
+}
+
+// dialSentinel connects to any of the sentinels known by the tracked
+// Sentinels, falling back to the addresses NewSentinelManager was called with.
+func (sm *SentinelManager) dialSentinel() (Conn, error) {
+	sm.l.RLock()
+	addrs := map[string]bool{}
+	for _, sc := range sm.sentinels {
+		sc.l.RLock()
+		for addr := range sc.sentinelAddrs {
+			addrs[addr] = true
+		}
+		sc.l.RUnlock()
+	}
+	sm.l.RUnlock()
+
+	var conn Conn
+	var err error
+	for addr := range addrs {
+		if conn, err = sm.so.cf("tcp", addr); err == nil {
+			return conn, nil
+		}
+	}
+
+	for _, addr := range sm.initAddrs {
+		if conn, err = sm.so.cf("tcp", addr); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// Sentinel returns the Sentinel for the primary with the given name, starting
+// to track it if it isn't already. All calls with the same name return the
+// same Sentinel until it is closed.
+//
+// The returned Sentinel doesn't have its own connection to a sentinel or its
+// own background goroutine, its state is updated by the SentinelManager
+// instead. Closing it stops the SentinelManager from tracking the primary and
+// closes its Clients. All Sentinels are closed when the SentinelManager is
+// closed.
+func (sm *SentinelManager) Sentinel(name string) (*Sentinel, error) {
+	sm.l.RLock()
+	sc, closed := sm.sentinels[name], sm.closed
+	sm.l.RUnlock()
+
+	if closed {
+		return nil, errClientClosed
+	} else if sc != nil {
+		return sc, nil
+	}
+
+	sc = newSentinel(name, sm.initAddrs, sm.so)
+	sc.pconn, sc.requeryCh, sc.manager = sm.pconn, sm.requeryCh, sm
+
+	conn, err := sm.dialSentinel()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := sc.ensureSentinelAddrs(conn); err != nil {
+		return nil, err
+	} else if err := sc.ensureClients(conn, false); err != nil {
+		return nil, err
+	}
+
+	// two routines might be requesting the same name at the same time. The
+	// second one needs to make sure it closes its own Sentinel when it sees
+	// the other got there first.
+	sm.l.Lock()
+	existing, closed := sm.sentinels[name], sm.closed
+	if existing == nil && !closed {
+		sm.sentinels[name] = sc
+		sc.closeWG.Add(1)
+		go sc.eventsSpin()
+	}
+	sm.l.Unlock()
+
+	if closed {
+		sc.Close()
+		return nil, errClientClosed
+	} else if existing != nil {
+		sc.Close()
+		return existing, nil
+	}
+	return sc, nil
+}
+
+// Names returns the sorted names of all primaries currently being tracked.
+func (sm *SentinelManager) Names() []string {
+	sm.l.RLock()
+	defer sm.l.RUnlock()
+	names := make([]string, 0, len(sm.sentinels))
+	for name := range sm.sentinels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// remove stops tracking sc, if it is still tracked. It is called by sc's Close
+// method, before sc's eventsSpin is stopped, since the shared pconn may be
+// blocked on writing to sc.eventsMsgCh until then.
+func (sm *SentinelManager) remove(sc *Sentinel) {
+	sm.l.Lock()
+	if sm.sentinels[sc.name] == sc {
+		delete(sm.sentinels, sc.name)
+	}
+	sm.l.Unlock()
+
+	sc.eventsL.Lock()
+	defer sc.eventsL.Unlock()
+	if sc.eventsSubbed {
+		sm.pconn.PUnsubscribe(sc.eventsMsgCh, "+*", "-*")
+		sc.eventsSubbed = false
+	}
+}
+
+// Close closes all Sentinels handed out by the SentinelManager, and stops
+// tracking their primaries.
+func (sm *SentinelManager) Close() error {
+	closeErr := errClientClosed
+	sm.closeOnce.Do(func() {
+		sm.l.Lock()
+		sm.closed = true
+		scs := sm.sortedSentinels()
+		sm.l.Unlock()
+
+		// the Sentinels use the pconn when closing, so they must be closed
+		// before the spin, which closes the pconn
+		for _, sc := range scs {
+			sc.Close()
+		}
+
+		close(sm.closeCh)
+		sm.closeWG.Wait()
+		closeErr = nil
+	})
+	return closeErr
+}
+
+func (sm *SentinelManager) spin() {
+	defer sm.closeWG.Done()
+	defer sm.pconn.Close()
+	for {
+		if err := sm.innerSpin(); err != nil {
+			sm.err(err)
+			// sleep a second so we don't end up in a tight loop
+			time.Sleep(1 * time.Second)
+		}
+		// This also gets checked within innerSpin to short-circuit that, but
+		// we also must check in here to short-circuit this
+		select {
+		case <-sm.closeCh:
+			return
+		default:
+		}
+	}
+}
+
+// ensureAll updates the state of all tracked Sentinels using conn. Errors
+// specific to a single primary are written to the ErrCh of its Sentinel, and
+// only an error of conn itself is returned.
+func (sm *SentinelManager) ensureAll(conn *ioErrConn, verifyPrim bool) error {
+	sm.l.RLock()
+	defer sm.l.RUnlock()
+
+	for _, sc := range sm.sortedSentinels() {
+		err := sc.ensureSentinelAddrs(conn)
+		if err == nil {
+			err = sc.ensureClients(conn, verifyPrim)
+		}
+
+		if conn.lastIOErr != nil {
+			return conn.lastIOErr
+		} else if err != nil {
+			sc.err(err)
+		}
+	}
+	return nil
+}
+
+// makes connection to a known sentinel and handles all tracked primaries until
+// that connection goes bad. It's the same as Sentinel's innerSpin, except that
+// a switch-master event or a READONLY error of any primary causes all
+// primaries to be re-checked.
+func (sm *SentinelManager) innerSpin() error {
+	c, err := sm.dialSentinel()
+	if err != nil {
+		return err
+	}
+	conn := newIOErrConn(c)
+	defer conn.Close()
+
+	tick := time.NewTicker(5 * time.Second)
+	defer tick.Stop()
+
+	var switchMaster, verifyPrim bool
+	for {
+		if err := sm.ensureAll(conn, verifyPrim); err != nil {
+			return err
+		}
+		sm.pconn.Ping()
+
+		// the tests want to know when the client state has been updated due to
+		// a switch-master event or a re-check of the primaries
+		if switchMaster {
+			sm.testEvent("switch-master completed")
+			switchMaster = false
+		}
+		if verifyPrim {
+			sm.testEvent("primary verified")
+			verifyPrim = false
+		}
+
+		select {
+		case <-tick.C:
+			// loop
+		case <-sm.pconnCh:
+			switchMaster = true
+			// loop
+		case <-sm.requeryCh:
+			verifyPrim = true
+			// loop
+		case <-sm.closeCh:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package radix
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSentinelManager(t *T) {
+	stub := newSentinelStub("", []string{"C:0"}, []string{"127.0.0.1:26379", "127.0.0.2:26379"})
+	stub.namedPrimAddrs = map[string]string{"a": "A:0", "b": "B:0"}
+	poolFn := func(network, addr string) (Client, error) {
+		return &stubSentinelPool{addr: addr}, nil
+	}
+
+	sm, err := NewSentinelManager(
+		stub.sentAddrs,
+		SentinelConnFunc(stub.newConn), SentinelPoolFunc(poolFn),
+	)
+	require.Nil(t, err)
+	defer sm.Close()
+
+	scA, err := sm.Sentinel("a")
+	require.Nil(t, err)
+	scB, err := sm.Sentinel("b")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, sm.Names())
+
+	scA2, err := sm.Sentinel("a")
+	require.Nil(t, err)
+	assert.True(t, scA == scA2)
+
+	assertPrimAddr := func(sc *Sentinel, exp string) {
+		primAddr, secAddrs := sc.Addrs()
+		assert.Equal(t, exp, primAddr)
+		assert.Equal(t, []string{"C:0"}, secAddrs)
+	}
+	assertPrimAddr(scA, "A:0")
+	assertPrimAddr(scB, "B:0")
+
+	stub.switchNamedPrimary("b", "D:0")
+	assert.Equal(t, "switch-master completed", <-sm.testEventCh)
+	assertPrimAddr(scA, "A:0")
+	assertPrimAddr(scB, "D:0")
+
+	// all primaries share the connection of the spin and the pconn
+	stub.Lock()
+	assert.Len(t, stub.stubChs, 2)
+	stub.Unlock()
+
+	ch := make(chan SentinelEvent, 10)
+	scB.SubscribeEvents(ch)
+	stub.publishEvent("+sdown", "master a A 0")
+	stub.publishEvent("+sdown", "master b D 0")
+	assert.Equal(t, "b", (<-ch).Name)
+
+	// closing a Sentinel stops tracking its primary
+	oldClient, err := scB.Client("D:0")
+	require.Nil(t, err)
+	require.Nil(t, scB.Close())
+	assert.True(t, oldClient.(*stubSentinelPool).closed)
+	assert.Equal(t, []string{"a"}, sm.Names())
+
+	scB2, err := sm.Sentinel("b")
+	require.Nil(t, err)
+	assert.False(t, scB == scB2)
+	assertPrimAddr(scB2, "D:0")
+
+	require.Nil(t, sm.Close())
+	assert.Empty(t, sm.Names())
+	_, err = sm.Sentinel("a")
+	assert.Equal(t, errClientClosed, err)
+}
@@ -0,0 +1,197 @@
+package radix
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// SentinelReadOnlyAction is an Action which is aware of the read routing done
+// by the Client returned from Sentinel's ReadClient method. If an Action
+// implements SentinelReadOnlyAction and the SentinelReadOnly method returns
+// true, then the Action may be performed on a replica instead of the primary.
+//
+// NOTE that the Actions which are returned by Cmd and FlatCmd, as well as
+// Pipelines only containing such Actions, are considered read-only if their
+// commands are known to never modify data, even though they don't implement
+// this interface.
+type SentinelReadOnlyAction interface {
+	Action
+	SentinelReadOnly() bool
+}
+
+// readOnlyCmds contains commands which never modify data, and can therefore be
+// performed on replicas.
+var readOnlyCmds = map[string]bool{
+	"BITCOUNT": true, "BITPOS": true, "GETBIT": true,
+	"DBSIZE": true, "DUMP": true, "EXISTS": true, "KEYS": true,
+	"PTTL": true, "RANDOMKEY": true, "SCAN": true, "TTL": true, "TYPE": true,
+	"EVAL_RO": true, "EVALSHA_RO": true,
+
+	"GET": true, "GETRANGE": true, "MGET": true, "STRLEN": true,
+
+	"HEXISTS": true, "HGET": true, "HGETALL": true, "HKEYS": true,
+	"HLEN": true, "HMGET": true, "HSCAN": true, "HSTRLEN": true, "HVALS": true,
+
+	"LINDEX": true, "LLEN": true, "LPOS": true, "LRANGE": true,
+
+	"SCARD": true, "SDIFF": true, "SINTER": true, "SISMEMBER": true,
+	"SMEMBERS": true, "SMISMEMBER": true, "SRANDMEMBER": true, "SSCAN": true,
+	"SUNION": true,
+
+	"ZCARD": true, "ZCOUNT": true, "ZLEXCOUNT": true, "ZMSCORE": true,
+	"ZRANGE": true, "ZRANGEBYLEX": true, "ZRANGEBYSCORE": true, "ZRANK": true,
+	"ZREVRANGE": true, "ZREVRANGEBYLEX": true, "ZREVRANGEBYSCORE": true,
+	"ZREVRANK": true, "ZSCAN": true, "ZSCORE": true,
+
+	"PFCOUNT": true,
+
+	"GEODIST": true, "GEOHASH": true, "GEOPOS": true, "GEOSEARCH": true,
+
+	"XINFO": true, "XLEN": true, "XPENDING": true, "XRANGE": true,
+	"XREAD": true, "XREVRANGE": true,
+}
+
+func isReadOnlyAction(a Action) bool {
+	switch a := a.(type) {
+	case SentinelReadOnlyAction:
+		return a.SentinelReadOnly()
+	case sentinelRetryableAction, sentinelRetryableCmdAction:
+		return isReadOnlyAction(unwrapSentinelRetryable(a))
+	case *cmdAction:
+		return readOnlyCmds[strings.ToUpper(a.cmd)]
+	case pipeline:
+		for _, cmd := range a {
+			if !isReadOnlyAction(cmd) {
+				return false
+			}
+		}
+		return len(a) > 0
+	default:
+		return false
+	}
+}
+
+// SentinelReplicaMaxLag tells the Sentinel to not route reads to replicas whose
+// replication offset, as reported by the sentinel, is more than maxLag bytes
+// behind the replica with the highest offset.
+//
+// By default the replication lag is not checked.
+func SentinelReplicaMaxLag(maxLag int64) SentinelOpt {
+	return func(so *sentinelOpts) {
+		so.replicaMaxLag = maxLag
+	}
+}
+
+// sentinelReplicaHealthy returns whether the replica described by m, as
+// returned by SENTINEL REPLICAS, can be used for reads.
+func sentinelReplicaHealthy(m map[string]string) bool {
+	for _, flag := range strings.Split(m["flags"], ",") {
+		switch flag {
+		case "s_down", "o_down", "disconnected":
+			return false
+		}
+	}
+
+	if status, ok := m["master-link-status"]; ok && status != "ok" {
+		return false
+	}
+	return true
+}
+
+// sentinelReadAddrs returns the sorted addresses of all replicas in secMM which
+// are healthy, and not lagging behind by more than maxLag if maxLag is
+// positive.
+func sentinelReadAddrs(secMM []map[string]string, maxLag int64) []string {
+	offsets := map[string]int64{}
+	var maxOffset int64
+	for _, secM := range secMM {
+		addr, err := sentinelMtoAddr(secM, "SENTINEL REPLICAS")
+		if err != nil || !sentinelReplicaHealthy(secM) {
+			continue
+		}
+
+		// replicas without a known offset are only excluded if lag is checked
+		offset, err := strconv.ParseInt(secM["slave-repl-offset"], 10, 64)
+		if err != nil {
+			offset = -1
+		}
+		offsets[addr] = offset
+		if offset > maxOffset {
+			maxOffset = offset
+		}
+	}
+
+	addrs := make([]string, 0, len(offsets))
+	for addr, offset := range offsets {
+		if maxLag > 0 && (offset < 0 || maxOffset-offset > maxLag) {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// nextReadAddr returns the address of the next healthy replica to perform a
+// read on, or false if there is none.
+func (sc *Sentinel) nextReadAddr() (string, bool) {
+	sc.l.RLock()
+	defer sc.l.RUnlock()
+	if len(sc.readAddrs) == 0 {
+		return "", false
+	}
+	i := atomic.AddUint32(&sc.readCounter, 1)
+	return sc.readAddrs[i%uint32(len(sc.readAddrs))], true
+}
+
+type sentinelReadClient struct {
+	sc *Sentinel
+}
+
+// ReadClient returns a Client which performs read-only Actions on the healthy
+// replicas of the primary, distributing them round-robin. All other Actions,
+// as well as read-only Actions while there are no healthy replicas, are
+// performed on the primary like with the Sentinel's Do method. See
+// SentinelReadOnlyAction for which Actions are considered read-only.
+//
+// Replicas which the sentinel flags as s_down, o_down or disconnected, or
+// whose link to the primary is down, are skipped. Use SentinelReplicaMaxLag to
+// also skip replicas which are lagging behind.
+//
+// The set of replicas is updated together with the primary, so the returned
+// Client follows failovers and other changes in topology automatically.
+// Replicas only see writes after they were replicated, so data written through
+// the Sentinel may not be immediately visible through the returned Client.
+//
+// NOTE the returned Client uses the Clients created by the Sentinel, so closing
+// it does nothing; they are closed when the Sentinel is closed.
+func (sc *Sentinel) ReadClient() Client {
+	return &sentinelReadClient{sc: sc}
+}
+
+func (rc *sentinelReadClient) Do(a Action) error {
+	if !isReadOnlyAction(a) {
+		return rc.sc.Do(a)
+	}
+
+	addr, ok := rc.sc.nextReadAddr()
+	if !ok {
+		return rc.sc.Do(a)
+	}
+
+	// the replica may have been removed since nextReadAddr was called, or a
+	// Client to it may not be creatable. Either way the primary can still be
+	// used.
+	client, err := rc.sc.Client(addr)
+	if err != nil {
+		rc.sc.err(err)
+		return rc.sc.Do(a)
+	}
+	return client.Do(a)
+}
+
+func (rc *sentinelReadClient) Close() error {
+	return nil
+}
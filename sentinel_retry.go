@@ -0,0 +1,110 @@
+package radix
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SentinelRetryableAction is an Action which is aware of the failover handling
+// done by Sentinel's Do method. If an Action implements SentinelRetryableAction
+// and the SentinelRetryable method returns true, then the Action may be
+// performed more than once if it fails due to a failover.
+//
+// Only Actions which can safely be performed multiple times, e.g. reads or
+// idempotent writes like SET, should be marked as retryable. See
+// SentinelRetryable for marking an existing Action.
+type SentinelRetryableAction interface {
+	Action
+	SentinelRetryable() bool
+}
+
+type sentinelRetryableAction struct {
+	Action
+}
+
+func (sentinelRetryableAction) SentinelRetryable() bool {
+	return true
+}
+
+// sentinelRetryableCmdAction is used instead of sentinelRetryableAction for
+// CmdActions, so that they can still be used as such, e.g. in a Pipeline or
+// for automatic pipelining.
+type sentinelRetryableCmdAction struct {
+	CmdAction
+}
+
+func (sentinelRetryableCmdAction) SentinelRetryable() bool {
+	return true
+}
+
+func (a sentinelRetryableCmdAction) PipelineCmds() []string {
+	if pa, ok := a.CmdAction.(PipelineableAction); ok {
+		return pa.PipelineCmds()
+	}
+	return nil
+}
+
+// SentinelRetryable wraps the given Action such that it implements
+// SentinelRetryableAction, marking it as safe to be retried after a failover.
+// See SentinelFailoverRetry.
+//
+// If the given Action is a CmdAction the returned one is as well, and it
+// implements PipelineableAction if the given one does.
+func SentinelRetryable(a Action) Action {
+	if ca, ok := a.(CmdAction); ok {
+		return sentinelRetryableCmdAction{CmdAction: ca}
+	}
+	return sentinelRetryableAction{Action: a}
+}
+
+// unwrapSentinelRetryable returns the Action wrapped by SentinelRetryable, or a
+// itself if it wasn't wrapped.
+func unwrapSentinelRetryable(a Action) Action {
+	switch a := a.(type) {
+	case sentinelRetryableAction:
+		return a.Action
+	case sentinelRetryableCmdAction:
+		return a.CmdAction
+	default:
+		return a
+	}
+}
+
+func isRetryableAction(a Action) bool {
+	ra, ok := a.(SentinelRetryableAction)
+	return ok && ra.SentinelRetryable()
+}
+
+// SentinelFailoverRetry tells the Sentinel to retry Actions marked as
+// retryable (see SentinelRetryableAction) on the new primary when they fail
+// due to a failover, instead of returning the error. The Sentinel waits up to
+// maxWait for a new primary, after which the last error is returned.
+//
+// Errors considered to be caused by a failover are READONLY and LOADING
+// errors, as well as the connection to the primary being refused, reset or
+// closed.
+//
+// By default Actions are not retried.
+func SentinelFailoverRetry(maxWait time.Duration) SentinelOpt {
+	return func(so *sentinelOpts) {
+		so.failoverMaxWait = maxWait
+	}
+}
+
+// isSentinelFailoverErr returns whether err may have been caused by the primary
+// being demoted, restarted or going down during a failover.
+func isSentinelFailoverErr(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	errStr := err.Error()
+	return strings.HasPrefix(errStr, "READONLY") ||
+		strings.HasPrefix(errStr, "LOADING") ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF)
+}
@@ -3,11 +3,13 @@ package radix
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/vikram-suki/radix/v3/internal/bytesutil"
@@ -114,6 +116,92 @@ func (s *StreamEntryID) UnmarshalRESP(br *bufio.Reader) error {
 	return nil
 }
 
+// Special IDs which can be used in place of a concrete entry ID in stream
+// commands.
+//
+// Only StreamMinID and StreamMaxID represent an actual ID and can be parsed by
+// ParseStreamEntryID, the others depend on the state of the stream or group at
+// the time the command is run (see ErrStreamSpecialID).
+const (
+	// StreamMinID is the lowest possible ID (0-0), e.g. for XRANGE.
+	StreamMinID = "-"
+
+	// StreamMaxID is the highest possible ID, e.g. for XRANGE.
+	StreamMaxID = "+"
+
+	// StreamLastID is the ID of the last entry in the stream, e.g. for XREAD
+	// or XGROUP CREATE. A nil ID in StreamReaderOpts.Streams uses this for
+	// XREAD.
+	StreamLastID = "$"
+
+	// StreamUndeliveredID is used by XREADGROUP to only read entries never
+	// delivered to other consumers. A nil ID in StreamReaderOpts.Streams uses
+	// this for XREADGROUP.
+	StreamUndeliveredID = ">"
+
+	// StreamAutoID lets XADD generate the ID of the new entry.
+	StreamAutoID = "*"
+)
+
+// ErrStreamSpecialID is returned by ParseStreamEntryID for StreamLastID,
+// StreamUndeliveredID and StreamAutoID, which don't represent a fixed ID.
+var ErrStreamSpecialID = errors.New("special stream entry id does not represent a fixed id")
+
+// ParseStreamEntryID parses a stream entry ID in the format <time>-<seq> as
+// used by Redis. If the sequence part is omitted it is 0.
+//
+// StreamMinID and StreamMaxID are parsed as the lowest and highest possible
+// IDs. For all other special IDs ErrStreamSpecialID is returned, since they
+// depend on the state of the stream and must be passed to commands as is.
+func ParseStreamEntryID(s string) (StreamEntryID, error) {
+	switch s {
+	case StreamMinID:
+		return StreamEntryID{}, nil
+	case StreamMaxID:
+		return StreamEntryID{Time: math.MaxUint64, Seq: math.MaxUint64}, nil
+	case StreamLastID, StreamUndeliveredID, StreamAutoID:
+		return StreamEntryID{}, ErrStreamSpecialID
+	}
+
+	timeStr, seqStr := s, "0"
+	if split := strings.IndexByte(s, '-'); split != -1 {
+		timeStr, seqStr = s[:split], s[split+1:]
+	}
+
+	ms, err := strconv.ParseUint(timeStr, 10, 64)
+	if err != nil {
+		return StreamEntryID{}, errInvalidStreamID
+	}
+
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil {
+		return StreamEntryID{}, errInvalidStreamID
+	}
+
+	return StreamEntryID{Time: ms, Seq: seq}, nil
+}
+
+// StreamEntryIDFromTime returns the lowest ID of entries added at t, which
+// can be used to read or query entries added since t.
+//
+// The ID is based on the time of the Redis server, so any difference between
+// the clocks of the client and server will affect which entries are matched.
+// Times before the Unix epoch result in the lowest possible ID.
+func StreamEntryIDFromTime(t time.Time) StreamEntryID {
+	ms := t.UnixNano() / int64(time.Millisecond)
+	if ms < 0 {
+		ms = 0
+	}
+	return StreamEntryID{Time: uint64(ms)}
+}
+
+// Timestamp returns the time the entry was added, as given by the Time part of
+// the ID.
+func (s StreamEntryID) Timestamp() time.Time {
+	ms := int64(s.Time)
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+}
+
 var _ fmt.Stringer = (*StreamEntryID)(nil)
 
 // String returns the ID in the format <time>-<seq> (the same format used by Redis).
@@ -123,6 +211,11 @@ func (s StreamEntryID) String() string {
 	return string(s.bytes())
 }
 
+// StreamField is a single field and its value in a stream entry.
+type StreamField struct {
+	Name, Value string
+}
+
 // StreamEntry is an entry in a Redis stream as returned by XRANGE, XREAD and XREADGROUP.
 type StreamEntry struct {
 	// ID is the ID of the entry in a stream.
@@ -130,6 +223,41 @@ type StreamEntry struct {
 
 	// Fields contains the fields and values for the stream entry.
 	Fields map[string]string
+
+	// FieldList contains the same fields and values as Fields, but in the order
+	// in which they were added to the stream and including duplicate fields.
+	FieldList []StreamField
+}
+
+// Decode decodes the fields of the entry into v, which must be a pointer to a
+// struct, map or slice. Structs follow the same rules as when unmarshaling the
+// response of HGETALL using Cmd, see the package docs on struct scanning.
+//
+// Fields are decoded in the order given by FieldList, so if a field appears
+// more than once the last value wins. If FieldList is nil, e.g. because the
+// StreamEntry wasn't unmarshaled from a response, Fields is used instead.
+func (s StreamEntry) Decode(v interface{}) error {
+	fields := s.FieldList
+	if fields == nil && len(s.Fields) > 0 {
+		fields = make([]StreamField, 0, len(s.Fields))
+		for name, value := range s.Fields {
+			fields = append(fields, StreamField{Name: name, Value: value})
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := (resp2.ArrayHeader{N: len(fields) * 2}).MarshalRESP(buf); err != nil {
+		return err
+	}
+	for _, f := range fields {
+		var err error
+		err = marshalBulkString(err, buf, f.Name)
+		err = marshalBulkString(err, buf, f.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return resp2.Any{I: v}.UnmarshalRESP(bufio.NewReader(buf))
 }
 
 var _ resp.Unmarshaler = (*StreamEntry)(nil)
@@ -166,6 +294,12 @@ func (s *StreamEntry) UnmarshalRESP(br *bufio.Reader) error {
 		}
 	}
 
+	if cap(s.FieldList) < ah.N/2 {
+		s.FieldList = make([]StreamField, 0, ah.N/2)
+	} else {
+		s.FieldList = s.FieldList[:0]
+	}
+
 	var bs resp2.BulkString
 	for i := 0; i < ah.N; i += 2 {
 		if err := bs.UnmarshalRESP(br); err != nil {
@@ -176,6 +310,7 @@ func (s *StreamEntry) UnmarshalRESP(br *bufio.Reader) error {
 			return err
 		}
 		s.Fields[key] = bs.S
+		s.FieldList = append(s.FieldList, StreamField{Name: key, Value: bs.S})
 	}
 	return nil
 }
@@ -221,6 +356,29 @@ type StreamReaderOpts struct {
 	//
 	// If Count is 0, all available entries will be retrieved.
 	Count int
+
+	// Context can optionally be used to cancel the StreamReader.
+	//
+	// Once Context is done, any read currently blocked waiting for new entries is
+	// interrupted by closing the connection it uses, so that the connection is
+	// discarded instead of being returned to a Pool. If the Client is a Conn,
+	// the Conn itself will be closed.
+	//
+	// After Context is done, Next will return ok == false and Err will return the
+	// error of the Context (context.Canceled or context.DeadlineExceeded).
+	Context context.Context
+
+	// Checkpointer can optionally be used to store the ID of the last handled
+	// entry of each stream, giving at-least-once consumption without consumer
+	// groups. Use NewStreamReaderFromCheckpoint to create a StreamReader which
+	// continues after the stored IDs.
+	//
+	// Entries returned by Next are considered handled once Next is called
+	// again, at which point the ID of the last of them is saved. If saving
+	// fails, Next returns ok == false and Err returns the error.
+	//
+	// Checkpointer is ignored when using Group.
+	Checkpointer StreamCheckpointer
 }
 
 // StreamReader allows reading from on or more streams, always returning newer entries
@@ -237,11 +395,16 @@ type StreamReader interface {
 	// If there was an error, ok will be false. Otherwise, even if no entries were read, ok will be true.
 	//
 	// If there was an error, all future calls to Next will return ok == false.
+	//
+	// The fields of each entry can be decoded into a struct using StreamEntry.Decode.
 	Next() (stream string, entries []StreamEntry, ok bool)
 }
 
 // NewStreamReader returns a new StreamReader for the given client.
 //
+// When used with a Cluster all streams must belong to the same slot. Use
+// Cluster.NewStreamReader to read streams from different slots.
+//
 // Any changes on opts after calling NewStreamReader will have no effect.
 func NewStreamReader(c Client, opts StreamReaderOpts) StreamReader {
 	sr := &streamReader{c: c, opts: opts}
@@ -281,9 +444,9 @@ func NewStreamReader(c Client, opts StreamReaderOpts) StreamReader {
 		if id != nil {
 			sr.ids[stream] = id.String()
 		} else if sr.cmd == "XREAD" {
-			sr.ids[stream] = "$"
+			sr.ids[stream] = StreamLastID
 		} else if sr.cmd == "XREADGROUP" {
-			sr.ids[stream] = ">"
+			sr.ids[stream] = StreamUndeliveredID
 		}
 	}
 
@@ -314,16 +477,39 @@ type streamReader struct {
 
 	unread []streamReaderEntry
 	err    error
+
+	// set if the last entries returned by Next still have to be saved by the
+	// Checkpointer.
+	checkpoint       bool
+	checkpointStream string
+	checkpointID     StreamEntryID
 }
 
 func (sr *streamReader) backfill() bool {
+	ctx := sr.opts.Context
+	if ctx != nil {
+		if sr.err = ctx.Err(); sr.err != nil {
+			return false
+		}
+	}
+
 	sr.args = append(sr.args[:0], sr.fixedArgs...)
 
 	for _, s := range sr.streams {
 		sr.args = append(sr.args, sr.ids[s])
 	}
 
-	if sr.err = sr.c.Do(Cmd(&sr.unread, sr.cmd, sr.args...)); sr.err != nil {
+	cmd := Cmd(&sr.unread, sr.cmd, sr.args...)
+	if ctx != nil && ctx.Done() != nil {
+		cmd = ctxCmdAction{CmdAction: cmd, ctx: ctx}
+	}
+
+	if sr.err = sr.c.Do(cmd); sr.err != nil {
+		// the error is most likely caused by the connection being closed, but
+		// the caller cares about why it was closed.
+		if ctx != nil && ctx.Err() != nil {
+			sr.err = ctx.Err()
+		}
 		return false
 	}
 
@@ -341,6 +527,13 @@ func (sr *streamReader) Next() (stream string, entries []StreamEntry, ok bool) {
 		return "", nil, false
 	}
 
+	if sr.checkpoint {
+		if sr.err = sr.opts.Checkpointer.Save(sr.checkpointStream, sr.checkpointID); sr.err != nil {
+			return "", nil, false
+		}
+		sr.checkpoint = false
+	}
+
 	if len(sr.unread) == 0 && !sr.backfill() {
 		return "", nil, false
 	}
@@ -357,16 +550,83 @@ func (sr *streamReader) Next() (stream string, entries []StreamEntry, ok bool) {
 		stream = string(sre.stream)
 
 		// do not update the ID for XREADGROUP when we are not reading unacknowledged entries.
-		if sr.cmd == "XREAD" || (sr.cmd == "XREADGROUP" && sr.ids[stream] != ">") {
+		if sr.cmd == "XREAD" || (sr.cmd == "XREADGROUP" && sr.ids[stream] != StreamUndeliveredID) {
 			sr.ids[stream] = sre.entries[len(sre.entries)-1].ID.String()
 		}
 
+		if sr.cmd == "XREAD" && sr.opts.Checkpointer != nil {
+			sr.checkpoint = true
+			sr.checkpointStream = stream
+			sr.checkpointID = sre.entries[len(sre.entries)-1].ID
+		}
+
 		return stream, sre.entries, true
 	}
 
 	return "", nil, true
 }
 
+// ctxCmdAction wraps a CmdAction so that the Conn it is run on is closed if
+// ctx is done while the CmdAction is running.
+type ctxCmdAction struct {
+	CmdAction
+	ctx context.Context
+}
+
+func (c ctxCmdAction) Run(conn Conn) error {
+	var closed bool
+	doneCh, closedCh := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(closedCh)
+		select {
+		case <-c.ctx.Done():
+			closeConnUnder(conn)
+			closed = true
+		case <-doneCh:
+		}
+	}()
+
+	err := c.CmdAction.Run(conn)
+	close(doneCh)
+	<-closedCh
+
+	// the CmdAction might have finished successfully just before the Conn was
+	// closed. Now that nothing else is using the Conn, close it again so that
+	// any wrappers know it can't be used anymore.
+	if closed {
+		conn.Close()
+	}
+	return err
+}
+
+func (c ctxCmdAction) ClusterCanRetry() bool {
+	return true
+}
+
+// PipelineCmds overrides the method of the wrapped CmdAction, since the Conn a
+// pipelined CmdAction is performed on is shared with other Actions, and must
+// not be closed.
+func (c ctxCmdAction) PipelineCmds() []string {
+	return nil
+}
+
+// closeConnUnder closes the Conn underlying any Conn wrappers of this package,
+// which would otherwise race with Encode and Decode calls on the wrapper. The
+// wrappers will see the resulting network error like any other.
+func closeConnUnder(conn Conn) {
+	for {
+		switch c := conn.(type) {
+		case *ioErrConn:
+			conn = c.Conn
+		case askConn:
+			conn = c.Conn
+		default:
+			conn.Close()
+			return
+		}
+	}
+}
+
 type streamReaderEntry struct {
 	stream  []byte
 	entries []StreamEntry
@@ -390,3 +650,127 @@ func (s *streamReaderEntry) UnmarshalRESP(br *bufio.Reader) error {
 
 	return (resp2.Any{I: &s.entries}).UnmarshalRESP(br)
 }
+
+// ErrNoStream is returned by a StreamWriter using NoMkStream when the stream
+// does not exist.
+var ErrNoStream = errors.New("stream does not exist")
+
+// StreamWriterOpts contains various options given for NewStreamWriter that
+// influence the behaviour of each XADD.
+type StreamWriterOpts struct {
+	// MaxLen optionally trims the stream to the given number of entries on each
+	// add, by passing MAXLEN to XADD. It may be 0, which keeps no entries at
+	// all.
+	//
+	// Only one of MaxLen and MinID may be set, setting both is an error.
+	MaxLen *int64
+
+	// MinID optionally trims the stream on each add by evicting all entries
+	// with an ID lower than MinID, by passing MINID to XADD (Redis 6.2+).
+	MinID *StreamEntryID
+
+	// Approx enables approximate trimming ("~") for MaxLen and MinID, which
+	// is much more efficient than exact trimming.
+	Approx bool
+
+	// NoMkStream disables creating the stream if it does not exist yet
+	// (Redis 6.2+). Adding to a stream which does not exist returns ErrNoStream.
+	NoMkStream bool
+}
+
+// StreamWriterEntry describes a single entry to be added by a StreamWriter.
+type StreamWriterEntry struct {
+	// ID is an optional explicit ID for the entry. If ID is nil, Redis will
+	// generate an ID ("*").
+	ID *StreamEntryID
+
+	// Fields contains the fields and values of the entry. It can be a
+	// map[string]string, a struct or anything else FlatCmd flattens into
+	// alternating keys and values. Structs follow the same rules as in FlatCmd,
+	// see the package docs on struct scanning.
+	Fields interface{}
+}
+
+// StreamWriter adds entries to a single stream using XADD.
+type StreamWriter struct {
+	c      Client
+	stream string
+
+	// options that come directly after the stream in each XADD
+	fixedArgs []interface{}
+}
+
+// NewStreamWriter returns a new StreamWriter which adds entries to the given
+// stream using the given client. An error is returned if opts are invalid.
+//
+// Any changes on opts after calling NewStreamWriter will have no effect.
+func NewStreamWriter(c Client, stream string, opts StreamWriterOpts) (*StreamWriter, error) {
+	sw := &StreamWriter{c: c, stream: stream}
+
+	if opts.NoMkStream {
+		sw.fixedArgs = append(sw.fixedArgs, "NOMKSTREAM")
+	}
+
+	trimOpts := StreamTrimOpts{MaxLen: opts.MaxLen, MinID: opts.MinID, Approx: opts.Approx}
+	trimArgs, err := trimOpts.args()
+	if err != nil {
+		return nil, err
+	}
+	for _, arg := range trimArgs {
+		sw.fixedArgs = append(sw.fixedArgs, arg)
+	}
+
+	return sw, nil
+}
+
+func (sw *StreamWriter) cmd(rcv *MaybeNil, entry StreamWriterEntry) CmdAction {
+	id := StreamAutoID
+	if entry.ID != nil {
+		id = entry.ID.String()
+	}
+
+	args := make([]interface{}, 0, len(sw.fixedArgs)+2)
+	args = append(args, sw.fixedArgs...)
+	args = append(args, id, entry.Fields)
+	return FlatCmd(rcv, "XADD", sw.stream, args...)
+}
+
+// Add adds a single entry with the given fields to the stream and returns its
+// ID. See StreamWriterEntry for the supported types of fields.
+func (sw *StreamWriter) Add(fields interface{}) (StreamEntryID, error) {
+	ids, err := sw.AddEntries([]StreamWriterEntry{{Fields: fields}})
+	if err != nil {
+		return StreamEntryID{}, err
+	}
+	return ids[0], nil
+}
+
+// AddEntries adds all given entries to the stream in a single Pipeline and
+// returns the IDs assigned to them, in the same order as the entries.
+//
+// Since Pipeline is not transactional, an error may be returned after some of
+// the entries have already been added.
+func (sw *StreamWriter) AddEntries(entries []StreamWriterEntry) ([]StreamEntryID, error) {
+	ids := make([]StreamEntryID, len(entries))
+	rcvs := make([]MaybeNil, len(entries))
+	cmds := make([]CmdAction, len(entries))
+	for i := range entries {
+		rcvs[i].Rcv = &ids[i]
+		cmds[i] = sw.cmd(&rcvs[i], entries[i])
+	}
+
+	var a Action = Pipeline(cmds...)
+	if len(cmds) == 1 {
+		a = cmds[0]
+	}
+	if err := sw.c.Do(a); err != nil {
+		return nil, err
+	}
+
+	for i := range rcvs {
+		if rcvs[i].Nil {
+			return nil, ErrNoStream
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,451 @@
+package radix
+
+import (
+	"bufio"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/vikram-suki/radix/v3/resp"
+	"github.com/vikram-suki/radix/v3/resp/resp2"
+)
+
+var errInvalidStreamInfo = errors.New("invalid stream info response")
+
+// unmarshalStreamInfoKVs reads an array of alternating keys and values, as
+// returned by XINFO, and calls fn for each key with its raw value.
+func unmarshalStreamInfoKVs(br *bufio.Reader, fn func(key string, val resp2.RawMessage) error) error {
+	var rms []resp2.RawMessage
+	if err := (resp2.Any{I: &rms}).UnmarshalRESP(br); err != nil {
+		return err
+	} else if len(rms)%2 != 0 {
+		return errInvalidStreamInfo
+	}
+
+	for i := 0; i < len(rms); i += 2 {
+		var key string
+		if err := rms[i].UnmarshalInto(resp2.Any{I: &key}); err != nil {
+			return err
+		} else if err := fn(key, rms[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmarshalStreamInfoInt unmarshals val into i, or sets i to -1 if val is nil.
+func unmarshalStreamInfoInt(val resp2.RawMessage, i *int64) error {
+	if val.IsNil() {
+		*i = -1
+		return nil
+	}
+	return val.UnmarshalInto(resp2.Any{I: i})
+}
+
+// unmarshalStreamInfoID unmarshals val into id, leaving it unchanged if val is
+// nil.
+func unmarshalStreamInfoID(val resp2.RawMessage, id *StreamEntryID) error {
+	if val.IsNil() {
+		return nil
+	}
+	return val.UnmarshalInto(id)
+}
+
+// unmarshalStreamInfoEntry unmarshals val into a new StreamEntry, or sets
+// entry to nil if val is nil.
+func unmarshalStreamInfoEntry(val resp2.RawMessage, entry **StreamEntry) error {
+	if val.IsNil() {
+		*entry = nil
+		return nil
+	}
+	*entry = new(StreamEntry)
+	return val.UnmarshalInto(*entry)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// StreamInfo describes a stream as returned by XINFO STREAM.
+//
+// EntriesAdded is set to -1 if it's not returned by the Redis version used, and
+// the other fields only returned by newer versions are left zero.
+type StreamInfo struct {
+	Length         int64
+	RadixTreeKeys  int64
+	RadixTreeNodes int64
+	Groups         int64
+
+	LastGeneratedID StreamEntryID
+
+	// Only returned by Redis 7.0+.
+	MaxDeletedEntryID    StreamEntryID
+	EntriesAdded         int64
+	RecordedFirstEntryID StreamEntryID
+
+	// FirstEntry and LastEntry are nil if the stream is empty.
+	FirstEntry, LastEntry *StreamEntry
+}
+
+var _ resp.Unmarshaler = (*StreamInfo)(nil)
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (si *StreamInfo) UnmarshalRESP(br *bufio.Reader) error {
+	*si = StreamInfo{EntriesAdded: -1}
+	return unmarshalStreamInfoKVs(br, func(key string, val resp2.RawMessage) error {
+		switch key {
+		case "length":
+			return unmarshalStreamInfoInt(val, &si.Length)
+		case "radix-tree-keys":
+			return unmarshalStreamInfoInt(val, &si.RadixTreeKeys)
+		case "radix-tree-nodes":
+			return unmarshalStreamInfoInt(val, &si.RadixTreeNodes)
+		case "groups":
+			return unmarshalStreamInfoInt(val, &si.Groups)
+		case "last-generated-id":
+			return unmarshalStreamInfoID(val, &si.LastGeneratedID)
+		case "max-deleted-entry-id":
+			return unmarshalStreamInfoID(val, &si.MaxDeletedEntryID)
+		case "entries-added":
+			return unmarshalStreamInfoInt(val, &si.EntriesAdded)
+		case "recorded-first-entry-id":
+			return unmarshalStreamInfoID(val, &si.RecordedFirstEntryID)
+		case "first-entry":
+			return unmarshalStreamInfoEntry(val, &si.FirstEntry)
+		case "last-entry":
+			return unmarshalStreamInfoEntry(val, &si.LastEntry)
+		default:
+			return nil
+		}
+	})
+}
+
+// StreamGroupInfo describes a consumer group as returned by XINFO GROUPS.
+type StreamGroupInfo struct {
+	Name      string
+	Consumers int64
+	Pending   int64
+
+	LastDeliveredID StreamEntryID
+
+	// EntriesRead and Lag are only returned by Redis 7.0+, and are -1 if not
+	// returned or if Redis can't determine them.
+	EntriesRead int64
+	Lag         int64
+}
+
+var _ resp.Unmarshaler = (*StreamGroupInfo)(nil)
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (sgi *StreamGroupInfo) UnmarshalRESP(br *bufio.Reader) error {
+	*sgi = StreamGroupInfo{EntriesRead: -1, Lag: -1}
+	return unmarshalStreamInfoKVs(br, func(key string, val resp2.RawMessage) error {
+		switch key {
+		case "name":
+			return val.UnmarshalInto(resp2.Any{I: &sgi.Name})
+		case "consumers":
+			return unmarshalStreamInfoInt(val, &sgi.Consumers)
+		case "pending":
+			return unmarshalStreamInfoInt(val, &sgi.Pending)
+		case "last-delivered-id":
+			return unmarshalStreamInfoID(val, &sgi.LastDeliveredID)
+		case "entries-read":
+			return unmarshalStreamInfoInt(val, &sgi.EntriesRead)
+		case "lag":
+			return unmarshalStreamInfoInt(val, &sgi.Lag)
+		default:
+			return nil
+		}
+	})
+}
+
+// StreamConsumerInfo describes a consumer of a group as returned by XINFO
+// CONSUMERS.
+type StreamConsumerInfo struct {
+	Name    string
+	Pending int64
+
+	// Idle is the time since the consumer last tried to interact with the
+	// stream.
+	Idle time.Duration
+
+	// Inactive is the time since the consumer last read entries successfully.
+	// It is only returned by Redis 7.2+ and is -1 if not returned or if the
+	// consumer never read any entries.
+	Inactive time.Duration
+}
+
+var _ resp.Unmarshaler = (*StreamConsumerInfo)(nil)
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (sci *StreamConsumerInfo) UnmarshalRESP(br *bufio.Reader) error {
+	*sci = StreamConsumerInfo{Inactive: -1}
+	return unmarshalStreamInfoKVs(br, func(key string, val resp2.RawMessage) error {
+		var ms int64
+		switch key {
+		case "name":
+			return val.UnmarshalInto(resp2.Any{I: &sci.Name})
+		case "pending":
+			return unmarshalStreamInfoInt(val, &sci.Pending)
+		case "idle":
+			if err := unmarshalStreamInfoInt(val, &ms); err != nil {
+				return err
+			}
+			sci.Idle = time.Duration(ms) * time.Millisecond
+		case "inactive":
+			if err := unmarshalStreamInfoInt(val, &ms); err != nil {
+				return err
+			} else if ms >= 0 {
+				sci.Inactive = time.Duration(ms) * time.Millisecond
+			}
+		}
+		return nil
+	})
+}
+
+// StreamPending summarizes the pending entries of a consumer group as returned
+// by XPENDING in its summary form.
+type StreamPending struct {
+	// Count is the total number of pending entries.
+	Count int64
+
+	// Lowest and Highest are the lowest and highest IDs of all pending
+	// entries. Both are zero if Count is 0.
+	Lowest, Highest StreamEntryID
+
+	// Consumers contains the number of pending entries for each consumer that
+	// has at least one pending entry.
+	Consumers map[string]int64
+}
+
+var _ resp.Unmarshaler = (*StreamPending)(nil)
+
+var errInvalidStreamPending = errors.New("invalid xpending response")
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (sp *StreamPending) UnmarshalRESP(br *bufio.Reader) error {
+	var rms []resp2.RawMessage
+	if err := (resp2.Any{I: &rms}).UnmarshalRESP(br); err != nil {
+		return err
+	} else if len(rms) != 4 {
+		return errInvalidStreamPending
+	}
+
+	*sp = StreamPending{Consumers: map[string]int64{}}
+	if err := rms[0].UnmarshalInto(resp2.Any{I: &sp.Count}); err != nil {
+		return err
+	} else if err := unmarshalStreamInfoID(rms[1], &sp.Lowest); err != nil {
+		return err
+	} else if err := unmarshalStreamInfoID(rms[2], &sp.Highest); err != nil {
+		return err
+	}
+
+	// each consumer is returned as a two element array of name and count
+	var consumers [][]string
+	if err := rms[3].UnmarshalInto(resp2.Any{I: &consumers}); err != nil {
+		return err
+	}
+	for _, c := range consumers {
+		if len(c) != 2 {
+			return errInvalidStreamPending
+		}
+		n, err := strconv.ParseInt(c[1], 10, 64)
+		if err != nil {
+			return errInvalidStreamPending
+		}
+		sp.Consumers[c[0]] = n
+	}
+	return nil
+}
+
+// StreamPendingEntry is a single pending entry of a consumer group as returned
+// by XPENDING in its extended form.
+type StreamPendingEntry struct {
+	ID       StreamEntryID
+	Consumer string
+
+	// Idle is the time since the entry was last delivered to Consumer.
+	Idle time.Duration
+
+	// Deliveries is the number of times the entry was delivered.
+	Deliveries int
+}
+
+var _ resp.Unmarshaler = (*StreamPendingEntry)(nil)
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (spe *StreamPendingEntry) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N != 4 {
+		return errInvalidStreamPending
+	}
+
+	if err := spe.ID.UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	var bs resp2.BulkString
+	if err := bs.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	spe.Consumer = bs.S
+
+	var i resp2.Int
+	if err := i.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	spe.Idle = time.Duration(i.I) * time.Millisecond
+
+	if err := i.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	spe.Deliveries = int(i.I)
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// XInfoStream returns information about the stream using XINFO STREAM.
+func XInfoStream(c Client, stream string) (StreamInfo, error) {
+	var info StreamInfo
+	err := c.Do(Cmd(&info, "XINFO", "STREAM", stream))
+	return info, err
+}
+
+// XInfoGroups returns information about all consumer groups of the stream
+// using XINFO GROUPS.
+func XInfoGroups(c Client, stream string) ([]StreamGroupInfo, error) {
+	var groups []StreamGroupInfo
+	err := c.Do(Cmd(&groups, "XINFO", "GROUPS", stream))
+	return groups, err
+}
+
+// XInfoConsumers returns information about all consumers of the group using
+// XINFO CONSUMERS.
+func XInfoConsumers(c Client, stream, group string) ([]StreamConsumerInfo, error) {
+	var consumers []StreamConsumerInfo
+	err := c.Do(Cmd(&consumers, "XINFO", "CONSUMERS", stream, group))
+	return consumers, err
+}
+
+// XPending returns a summary of the pending entries of the group using
+// XPENDING.
+func XPending(c Client, stream, group string) (StreamPending, error) {
+	var pending StreamPending
+	err := c.Do(Cmd(&pending, "XPENDING", stream, group))
+	return pending, err
+}
+
+// StreamPendingOpts contains the options given to XPendingEntries.
+type StreamPendingOpts struct {
+	// Start and End limit the range of returned entries, both inclusive. If
+	// nil, the range is not limited in that direction.
+	Start, End *StreamEntryID
+
+	// Count is the maximum number of entries returned.
+	//
+	// The default, if Count is 0, is 100.
+	Count int
+
+	// Consumer optionally limits the entries to the ones pending for the given
+	// consumer.
+	Consumer string
+
+	// MinIdle optionally limits the entries to the ones which have been idle
+	// for at least the given duration (Redis 6.2+).
+	MinIdle time.Duration
+}
+
+// XPendingEntries returns the pending entries of the group using the extended
+// form of XPENDING.
+func XPendingEntries(c Client, stream, group string, opts StreamPendingOpts) ([]StreamPendingEntry, error) {
+	start, end, count := StreamMinID, StreamMaxID, 100
+	if opts.Start != nil {
+		start = opts.Start.String()
+	}
+	if opts.End != nil {
+		end = opts.End.String()
+	}
+	if opts.Count > 0 {
+		count = opts.Count
+	}
+
+	args := []string{stream, group}
+	if opts.MinIdle > 0 {
+		args = append(args, "IDLE", strconv.FormatInt(int64(opts.MinIdle/time.Millisecond), 10))
+	}
+	args = append(args, start, end, strconv.Itoa(count))
+	if opts.Consumer != "" {
+		args = append(args, opts.Consumer)
+	}
+
+	var entries []StreamPendingEntry
+	err := c.Do(Cmd(&entries, "XPENDING", args...))
+	return entries, err
+}
+
+var errStreamTrimOpts = errors.New("only one of MaxLen and MinID may be set")
+
+// StreamTrimOpts contains the options given to XTrim.
+type StreamTrimOpts struct {
+	// MaxLen trims the stream to the given number of entries, which may be 0
+	// to delete all entries.
+	//
+	// Only one of MaxLen and MinID may be set, setting both is an error.
+	MaxLen *int64
+
+	// MinID trims the stream by evicting all entries with an ID lower than
+	// MinID (Redis 6.2+).
+	MinID *StreamEntryID
+
+	// Approx enables approximate trimming ("~"), which is much more efficient
+	// than exact trimming.
+	Approx bool
+}
+
+func (o StreamTrimOpts) args() ([]string, error) {
+	var args []string
+	if o.MaxLen != nil && o.MinID != nil {
+		return nil, errStreamTrimOpts
+	} else if o.MaxLen != nil {
+		args = []string{"MAXLEN", strconv.FormatInt(*o.MaxLen, 10)}
+	} else if o.MinID != nil {
+		args = []string{"MINID", o.MinID.String()}
+	}
+	if args != nil && o.Approx {
+		args = []string{args[0], "~", args[1]}
+	}
+	return args, nil
+}
+
+// XTrim trims the stream using XTRIM and returns the number of entries which
+// were deleted.
+func XTrim(c Client, stream string, opts StreamTrimOpts) (int64, error) {
+	args, err := opts.args()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	err = c.Do(Cmd(&n, "XTRIM", append([]string{stream}, args...)...))
+	return n, err
+}
+
+// XSetID sets the last generated ID of the stream using XSETID.
+func XSetID(c Client, stream string, id StreamEntryID) error {
+	return c.Do(Cmd(nil, "XSETID", stream, id.String()))
+}
+
+// XGroupSetID sets the last delivered ID of the consumer group using XGROUP
+// SETID.
+func XGroupSetID(c Client, stream, group string, id StreamEntryID) error {
+	return c.Do(Cmd(nil, "XGROUP", "SETID", stream, group, id.String()))
+}
+
+// XGroupDelConsumer deletes the consumer from the group using XGROUP
+// DELCONSUMER and returns the number of entries which were pending for it.
+func XGroupDelConsumer(c Client, stream, group, consumer string) (int64, error) {
+	var n int64
+	err := c.Do(Cmd(&n, "XGROUP", "DELCONSUMER", stream, group, consumer))
+	return n, err
+}
@@ -0,0 +1,143 @@
+package radix
+
+import (
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamAdminStub returns a Conn which replies to XINFO and XPENDING with
+// fixed responses, and records all other commands in argsCh.
+func streamAdminStub(argsCh chan<- []string) Conn {
+	return Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch strings.ToUpper(args[0]) + " " + strings.ToUpper(args[1]) {
+		case "XINFO STREAM":
+			return []interface{}{
+				"length", 2,
+				"radix-tree-keys", 1,
+				"radix-tree-nodes", 2,
+				"last-generated-id", "2-0",
+				"groups", 1,
+				"first-entry", []interface{}{"1-0", []string{"foo", "a"}},
+				"last-entry", []interface{}{"2-0", []string{"foo", "b"}},
+			}
+		case "XINFO GROUPS":
+			return []interface{}{
+				[]interface{}{
+					"name", "group",
+					"consumers", 1,
+					"pending", 1,
+					"last-delivered-id", "1-0",
+					"entries-read", 1,
+					"lag", nil,
+				},
+			}
+		case "XINFO CONSUMERS":
+			return []interface{}{
+				[]interface{}{"name", "consumer", "pending", 1, "idle", 1500},
+			}
+		}
+
+		if strings.ToUpper(args[0]) == "XPENDING" && len(args) == 3 {
+			return []interface{}{
+				2, "1-0", "2-0",
+				[]interface{}{[]string{"a", "1"}, []string{"b", "1"}},
+			}
+		} else if strings.ToUpper(args[0]) == "XPENDING" {
+			return []interface{}{
+				[]interface{}{"1-0", "a", 1500, 3},
+			}
+		}
+
+		argsCh <- args
+		return 1
+	})
+}
+
+func TestStreamAdmin(t *T) {
+	argsCh := make(chan []string, 1)
+	c := streamAdminStub(argsCh)
+	defer c.Close()
+
+	t.Run("XInfoStream", func(t *T) {
+		info, err := XInfoStream(c, "stream")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), info.Length)
+		assert.Equal(t, int64(1), info.Groups)
+		assert.Equal(t, int64(-1), info.EntriesAdded)
+		assert.Equal(t, StreamEntryID{Time: 2}, info.LastGeneratedID)
+		require.NotNil(t, info.FirstEntry)
+		assert.Equal(t, StreamEntryID{Time: 1}, info.FirstEntry.ID)
+		require.NotNil(t, info.LastEntry)
+		assert.Equal(t, map[string]string{"foo": "b"}, info.LastEntry.Fields)
+	})
+
+	t.Run("XInfoGroups", func(t *T) {
+		groups, err := XInfoGroups(c, "stream")
+		require.NoError(t, err)
+		assert.Equal(t, []StreamGroupInfo{{
+			Name:            "group",
+			Consumers:       1,
+			Pending:         1,
+			LastDeliveredID: StreamEntryID{Time: 1},
+			EntriesRead:     1,
+			Lag:             -1,
+		}}, groups)
+	})
+
+	t.Run("XInfoConsumers", func(t *T) {
+		consumers, err := XInfoConsumers(c, "stream", "group")
+		require.NoError(t, err)
+		assert.Equal(t, []StreamConsumerInfo{{
+			Name:     "consumer",
+			Pending:  1,
+			Idle:     1500 * time.Millisecond,
+			Inactive: -1,
+		}}, consumers)
+	})
+
+	t.Run("XPending", func(t *T) {
+		pending, err := XPending(c, "stream", "group")
+		require.NoError(t, err)
+		assert.Equal(t, StreamPending{
+			Count:     2,
+			Lowest:    StreamEntryID{Time: 1},
+			Highest:   StreamEntryID{Time: 2},
+			Consumers: map[string]int64{"a": 1, "b": 1},
+		}, pending)
+	})
+
+	t.Run("XPendingEntries", func(t *T) {
+		entries, err := XPendingEntries(c, "stream", "group", StreamPendingOpts{})
+		require.NoError(t, err)
+		assert.Equal(t, []StreamPendingEntry{{
+			ID:         StreamEntryID{Time: 1},
+			Consumer:   "a",
+			Idle:       1500 * time.Millisecond,
+			Deliveries: 3,
+		}}, entries)
+	})
+
+	t.Run("XTrim", func(t *T) {
+		maxLen := int64(10)
+		n, err := XTrim(c, "stream", StreamTrimOpts{MaxLen: &maxLen, Approx: true})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+		assert.Equal(t, []string{"XTRIM", "stream", "MAXLEN", "~", "10"}, <-argsCh)
+
+		maxLen = 0
+		_, err = XTrim(c, "stream", StreamTrimOpts{MaxLen: &maxLen})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"XTRIM", "stream", "MAXLEN", "0"}, <-argsCh)
+
+		_, err = XTrim(c, "stream", StreamTrimOpts{MinID: &StreamEntryID{Time: 5}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"XTRIM", "stream", "MINID", "5-0"}, <-argsCh)
+
+		_, err = XTrim(c, "stream", StreamTrimOpts{MaxLen: &maxLen, MinID: &StreamEntryID{Time: 5}})
+		assert.Equal(t, errStreamTrimOpts, err)
+	})
+}
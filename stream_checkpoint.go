@@ -0,0 +1,136 @@
+package radix
+
+import (
+	"errors"
+	"sync"
+)
+
+// StreamCheckpointer stores the ID of the last entry that was handled for each
+// stream read by a StreamReader, so that a new StreamReader can continue where
+// a previous one stopped. Implementations must be thread-safe.
+//
+// See the Checkpointer field of StreamReaderOpts.
+type StreamCheckpointer interface {
+	// Load returns the stored IDs for the given streams. Streams for which no
+	// ID was stored yet must be omitted from the returned map.
+	Load(streams []string) (map[string]StreamEntryID, error)
+
+	// Save stores id as the ID of the last handled entry of the stream.
+	Save(stream string, id StreamEntryID) error
+}
+
+// NewStreamReaderFromCheckpoint loads the IDs stored by the Checkpointer in
+// opts for all streams in opts.Streams and returns a StreamReader which
+// continues reading after them. Streams for which no ID was stored use the ID
+// given in opts.Streams. An error is returned if opts.Checkpointer is nil.
+//
+// If c is a *Cluster the StreamReader is created using Cluster.NewStreamReader,
+// otherwise NewStreamReader is used.
+//
+// Any changes on opts after calling NewStreamReaderFromCheckpoint will have no
+// effect.
+func NewStreamReaderFromCheckpoint(c Client, opts StreamReaderOpts) (StreamReader, error) {
+	if opts.Checkpointer == nil {
+		return nil, errors.New("Checkpointer must be set")
+	}
+
+	streams := make([]string, 0, len(opts.Streams))
+	for stream := range opts.Streams {
+		streams = append(streams, stream)
+	}
+
+	ids, err := opts.Checkpointer.Load(streams)
+	if err != nil {
+		return nil, err
+	}
+
+	origStreams := opts.Streams
+	opts.Streams = make(map[string]*StreamEntryID, len(origStreams))
+	for stream, id := range origStreams {
+		if storedID, ok := ids[stream]; ok {
+			id = &storedID
+		}
+		opts.Streams[stream] = id
+	}
+
+	if cluster, ok := c.(*Cluster); ok {
+		return cluster.NewStreamReader(opts), nil
+	}
+	return NewStreamReader(c, opts), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type memStreamCheckpointer struct {
+	l   sync.Mutex
+	ids map[string]StreamEntryID
+}
+
+// NewMemStreamCheckpointer returns a StreamCheckpointer which keeps all IDs in
+// memory. This is mostly useful for tests, or for sharing the position between
+// successive StreamReaders within the same process.
+func NewMemStreamCheckpointer() StreamCheckpointer {
+	return &memStreamCheckpointer{ids: map[string]StreamEntryID{}}
+}
+
+func (m *memStreamCheckpointer) Load(streams []string) (map[string]StreamEntryID, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	ids := make(map[string]StreamEntryID, len(streams))
+	for _, stream := range streams {
+		if id, ok := m.ids[stream]; ok {
+			ids[stream] = id
+		}
+	}
+	return ids, nil
+}
+
+func (m *memStreamCheckpointer) Save(stream string, id StreamEntryID) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.ids[stream] = id
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type hashStreamCheckpointer struct {
+	c   Client
+	key string
+}
+
+// NewHashStreamCheckpointer returns a StreamCheckpointer which stores the IDs in
+// the Redis hash at the given key, using the stream names as fields.
+func NewHashStreamCheckpointer(c Client, key string) StreamCheckpointer {
+	return &hashStreamCheckpointer{c: c, key: key}
+}
+
+func (h *hashStreamCheckpointer) Load(streams []string) (map[string]StreamEntryID, error) {
+	if len(streams) == 0 {
+		return map[string]StreamEntryID{}, nil
+	}
+
+	rawIDs := make([]StreamEntryID, len(streams))
+	rcvs := make([]MaybeNil, len(streams))
+	for i := range rcvs {
+		rcvs[i].Rcv = &rawIDs[i]
+	}
+
+	args := append([]string{h.key}, streams...)
+	if err := h.c.Do(Cmd(&rcvs, "HMGET", args...)); err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]StreamEntryID, len(streams))
+	for i, stream := range streams {
+		if !rcvs[i].Nil {
+			ids[stream] = rawIDs[i]
+		}
+	}
+	return ids, nil
+}
+
+func (h *hashStreamCheckpointer) Save(stream string, id StreamEntryID) error {
+	return h.c.Do(Cmd(nil, "HSET", h.key, stream, id.String()))
+}
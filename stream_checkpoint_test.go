@@ -0,0 +1,113 @@
+package radix
+
+import (
+	"strconv"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamCheckpointStub returns a Conn which serves HSET/HMGET from a single
+// hash, and XREAD from a single stream with the entries 1-0, 2-0 and 3-0.
+func streamCheckpointStub(stream string) Conn {
+	hash := map[string]string{}
+	return Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch strings.ToUpper(args[0]) {
+		case "HSET":
+			hash[args[2]] = args[3]
+			return 1
+		case "HMGET":
+			res := make([]interface{}, len(args)-2)
+			for i, field := range args[2:] {
+				if v, ok := hash[field]; ok {
+					res[i] = v
+				}
+			}
+			return res
+		case "XREAD":
+			count := 0
+			if strings.ToUpper(args[1]) == "COUNT" {
+				count, _ = strconv.Atoi(args[2])
+			}
+			after, _ := strconv.Atoi(strings.TrimSuffix(args[len(args)-1], "-0"))
+
+			var entries []interface{}
+			for i := after + 1; i <= 3 && (count == 0 || len(entries) < count); i++ {
+				entries = append(entries, []interface{}{strconv.Itoa(i) + "-0", []string{"i", strconv.Itoa(i)}})
+			}
+			if len(entries) == 0 {
+				return nil
+			}
+			return []interface{}{[]interface{}{stream, entries}}
+		}
+		return nil
+	})
+}
+
+func TestStreamCheckpointer(t *T) {
+	stream := randStr()
+
+	for name, cp := range map[string]StreamCheckpointer{
+		"Mem":  NewMemStreamCheckpointer(),
+		"Hash": NewHashStreamCheckpointer(streamCheckpointStub(stream), randStr()),
+	} {
+		t.Run(name, func(t *T) {
+			ids, err := cp.Load([]string{stream})
+			require.NoError(t, err)
+			assert.Empty(t, ids)
+
+			require.NoError(t, cp.Save(stream, StreamEntryID{Time: 1, Seq: 2}))
+			ids, err = cp.Load([]string{stream, randStr()})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]StreamEntryID{stream: {Time: 1, Seq: 2}}, ids)
+		})
+	}
+}
+
+func TestStreamReaderCheckpoint(t *T) {
+	stream := randStr()
+	conn := streamCheckpointStub(stream)
+	cp := NewMemStreamCheckpointer()
+	opts := StreamReaderOpts{
+		Streams:      map[string]*StreamEntryID{stream: {}},
+		NoBlock:      true,
+		Count:        1,
+		Checkpointer: cp,
+	}
+
+	r, err := NewStreamReaderFromCheckpoint(conn, opts)
+	require.NoError(t, err)
+	assertStreamReaderEntries(t, r, map[string][]StreamEntryID{stream: {{Time: 1}}})
+
+	// the first entry is only saved once Next is called again
+	ids, err := cp.Load([]string{stream})
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	assertStreamReaderEntries(t, r, map[string][]StreamEntryID{stream: {{Time: 2}}})
+	ids, err = cp.Load([]string{stream})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]StreamEntryID{stream: {Time: 1}}, ids)
+
+	// a new reader continues after the last saved entry, so the second entry
+	// is read again
+	r, err = NewStreamReaderFromCheckpoint(conn, opts)
+	require.NoError(t, err)
+	assertStreamReaderEntries(t, r, map[string][]StreamEntryID{stream: {{Time: 2}}})
+	assertStreamReaderEntries(t, r, map[string][]StreamEntryID{stream: {{Time: 3}}})
+	assertNoStreamReaderEntries(t, r)
+
+	ids, err = cp.Load([]string{stream})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]StreamEntryID{stream: {Time: 3}}, ids)
+}
+
+func TestStreamReaderCheckpointNoCheckpointer(t *T) {
+	stream := randStr()
+	_, err := NewStreamReaderFromCheckpoint(streamCheckpointStub(stream), StreamReaderOpts{
+		Streams: map[string]*StreamEntryID{stream: {}},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,146 @@
+package radix
+
+import (
+	"strconv"
+)
+
+// StreamRangeOpts contains the options given to XRange and
+// NewStreamRangeScanner.
+type StreamRangeOpts struct {
+	// Start and End limit the range of returned entries, both inclusive. If
+	// nil, the range is not limited in that direction.
+	Start, End *StreamEntryID
+
+	// Count limits the number of entries returned by XRange. If Count is 0 all
+	// entries in the range are returned.
+	//
+	// For NewStreamRangeScanner Count is the number of entries retrieved per
+	// call to Redis. The default, if Count is 0, is 100.
+	Count int
+
+	// Reverse uses XREVRANGE instead of XRANGE, returning entries from End to
+	// Start.
+	Reverse bool
+}
+
+func (o StreamRangeOpts) cmd(rcv interface{}, stream string) CmdAction {
+	start, end := StreamMinID, StreamMaxID
+	if o.Start != nil {
+		start = o.Start.String()
+	}
+	if o.End != nil {
+		end = o.End.String()
+	}
+
+	cmd, args := "XRANGE", []string{stream, start, end}
+	if o.Reverse {
+		cmd, args = "XREVRANGE", []string{stream, end, start}
+	}
+	if o.Count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(o.Count))
+	}
+
+	return Cmd(rcv, cmd, args...)
+}
+
+// XRange returns the entries of the stream within the range given by opts,
+// using XRANGE or XREVRANGE.
+func XRange(c Client, stream string, opts StreamRangeOpts) ([]StreamEntry, error) {
+	var entries []StreamEntry
+	err := c.Do(opts.cmd(&entries, stream))
+	return entries, err
+}
+
+// StreamRangeScanner is used to iterate through all entries of a stream range,
+// retrieving them in pages of a limited size.
+//
+// Once created, repeatedly call Next() on it to fill the passed in StreamEntry
+// pointer with the next entry. Next will return false if there's no more
+// entries to retrieve or if an error occurred, at which point Close should be
+// called to retrieve any error.
+type StreamRangeScanner interface {
+	Next(*StreamEntry) bool
+	Close() error
+}
+
+type streamRangeScanner struct {
+	c      Client
+	stream string
+	opts   StreamRangeOpts
+
+	res  []StreamEntry
+	done bool
+	err  error
+}
+
+// NewStreamRangeScanner creates a new StreamRangeScanner which iterates over
+// the entries of the stream within the range given by opts.
+//
+// Entries are retrieved using XRANGE (or XREVRANGE) with a COUNT of
+// opts.Count, continuing after the last retrieved entry for each page. Entries
+// added to the stream during the iteration are returned if they are within the
+// range and haven't been passed yet.
+//
+// Any changes on opts after calling NewStreamRangeScanner will have no effect.
+func NewStreamRangeScanner(c Client, stream string, opts StreamRangeOpts) StreamRangeScanner {
+	if opts.Count <= 0 {
+		opts.Count = 100
+	}
+	if opts.Start != nil {
+		start := *opts.Start
+		opts.Start = &start
+	}
+	if opts.End != nil {
+		end := *opts.End
+		opts.End = &end
+	}
+	return &streamRangeScanner{c: c, stream: stream, opts: opts}
+}
+
+func (s *streamRangeScanner) Next(res *StreamEntry) bool {
+	for {
+		if s.err != nil {
+			return false
+		}
+
+		if len(s.res) > 0 {
+			*res, s.res = s.res[0], s.res[1:]
+			return true
+		}
+
+		if s.done {
+			return false
+		}
+
+		// always use a new slice, since StreamEntry reuses the Fields map of
+		// existing entries, which were already returned.
+		s.res = nil
+		if s.err = s.c.Do(s.opts.cmd(&s.res, s.stream)); s.err != nil {
+			return false
+		} else if len(s.res) < s.opts.Count {
+			s.done = true
+		}
+
+		if len(s.res) == 0 {
+			continue
+		}
+
+		// continue the next page after the last entry. Prev and Next return
+		// the same ID at the bounds of the ID space, in which case there is
+		// nothing left to retrieve.
+		last := s.res[len(s.res)-1].ID
+		if s.opts.Reverse {
+			end := last.Prev()
+			s.done = s.done || end == last
+			s.opts.End = &end
+		} else {
+			start := last.Next()
+			s.done = s.done || start == last
+			s.opts.Start = &start
+		}
+	}
+}
+
+func (s *streamRangeScanner) Close() error {
+	return s.err
+}
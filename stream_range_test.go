@@ -0,0 +1,102 @@
+package radix
+
+import (
+	"strconv"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamRangeStub returns a Conn which serves XRANGE and XREVRANGE from a
+// single stream with the entries 1-0 to n-0.
+func streamRangeStub(n int) Conn {
+	return Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmd := strings.ToUpper(args[0])
+		start, end := args[2], args[3]
+		if cmd == "XREVRANGE" {
+			start, end = end, start
+		}
+		startID, _ := ParseStreamEntryID(start)
+		endID, _ := ParseStreamEntryID(end)
+
+		count := -1
+		if len(args) > 5 {
+			count, _ = strconv.Atoi(args[5])
+		}
+
+		var ids []StreamEntryID
+		for i := 1; i <= n; i++ {
+			id := StreamEntryID{Time: uint64(i)}
+			if !id.Before(startID) && !endID.Before(id) {
+				ids = append(ids, id)
+			}
+		}
+		if cmd == "XREVRANGE" {
+			for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+				ids[i], ids[j] = ids[j], ids[i]
+			}
+		}
+
+		entries := []interface{}{}
+		for _, id := range ids {
+			if count >= 0 && len(entries) == count {
+				break
+			}
+			entries = append(entries, []interface{}{id.String(), []string{"i", strconv.FormatUint(id.Time, 10)}})
+		}
+		return entries
+	})
+}
+
+func TestStreamRange(t *T) {
+	c := streamRangeStub(5)
+	ids := func(entries []StreamEntry) []uint64 {
+		var ids []uint64
+		for _, e := range entries {
+			ids = append(ids, e.ID.Time)
+		}
+		return ids
+	}
+
+	t.Run("XRange", func(t *T) {
+		entries, err := XRange(c, "stream", StreamRangeOpts{})
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{1, 2, 3, 4, 5}, ids(entries))
+
+		entries, err = XRange(c, "stream", StreamRangeOpts{
+			Start: &StreamEntryID{Time: 2},
+			End:   &StreamEntryID{Time: 4},
+			Count: 2,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{2, 3}, ids(entries))
+
+		entries, err = XRange(c, "stream", StreamRangeOpts{Start: &StreamEntryID{Time: 2}, Reverse: true})
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{5, 4, 3, 2}, ids(entries))
+	})
+
+	t.Run("Scanner", func(t *T) {
+		for _, test := range []struct {
+			opts StreamRangeOpts
+			exp  []uint64
+		}{
+			{StreamRangeOpts{Count: 2}, []uint64{1, 2, 3, 4, 5}},
+			{StreamRangeOpts{Count: 5}, []uint64{1, 2, 3, 4, 5}},
+			{StreamRangeOpts{Count: 2, Reverse: true}, []uint64{5, 4, 3, 2, 1}},
+			{StreamRangeOpts{Count: 2, End: &StreamEntryID{Time: 3}, Reverse: true}, []uint64{3, 2, 1}},
+			{StreamRangeOpts{Start: &StreamEntryID{Time: 6}}, nil},
+		} {
+			var entries []StreamEntry
+			var entry StreamEntry
+			s := NewStreamRangeScanner(c, "stream", test.opts)
+			for s.Next(&entry) {
+				entries = append(entries, entry)
+			}
+			require.NoError(t, s.Close())
+			assert.Equal(t, test.exp, ids(entries))
+		}
+	})
+}
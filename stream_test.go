@@ -3,6 +3,8 @@ package radix
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"io/ioutil"
 	"math"
 	"strconv"
@@ -12,6 +14,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vikram-suki/radix/v3/resp/resp2"
 )
 
 func TestStreamEntryID(t *T) {
@@ -289,6 +292,55 @@ func TestStreamEntryID(t *T) {
 			assert.Equal(t, test.E, s)
 		}
 	})
+
+	t.Run("Parse", func(t *T) {
+		for _, test := range []struct {
+			In      string
+			E       StreamEntryID
+			Err     bool
+			Special bool
+		}{
+			{In: "", Err: true},
+			{In: "0-", Err: true},
+			{In: "-0", Err: true},
+			{In: "0--0", Err: true},
+			{In: "0-+0", Err: true},
+			{In: "$", Err: true, Special: true},
+			{In: ">", Err: true, Special: true},
+			{In: "*", Err: true, Special: true},
+			{In: "0-0", E: StreamEntryID{Time: 0, Seq: 0}},
+			{In: "20-20", E: StreamEntryID{Time: 20, Seq: 20}},
+			{In: "1526919030474", E: StreamEntryID{Time: 1526919030474, Seq: 0}},
+			{In: "-", E: StreamEntryID{Time: 0, Seq: 0}},
+			{In: "+", E: StreamEntryID{Time: math.MaxUint64, Seq: math.MaxUint64}},
+		} {
+			s, err := ParseStreamEntryID(test.In)
+			if test.Err {
+				assert.Errorf(t, err, "expected error parsing %q", test.In)
+				assert.Equal(t, test.Special, errors.Is(err, ErrStreamSpecialID), "parsing %q", test.In)
+				continue
+			}
+			assert.NoErrorf(t, err, "failed to parse %q", test.In)
+			assert.Equal(t, test.E, s)
+
+			if test.In != "-" && test.In != "+" && strings.Contains(test.In, "-") {
+				assert.Equal(t, test.In, s.String())
+			}
+		}
+	})
+
+	t.Run("Time", func(t *T) {
+		tt := time.Date(2018, 5, 21, 16, 10, 30, 474000000, time.UTC)
+		s := StreamEntryIDFromTime(tt)
+		assert.Equal(t, StreamEntryID{Time: 1526919030474}, s)
+		assert.True(t, tt.Equal(s.Timestamp()))
+
+		s.Seq = 55
+		assert.True(t, tt.Equal(s.Timestamp()))
+
+		s = StreamEntryIDFromTime(time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC))
+		assert.Equal(t, StreamEntryID{}, s)
+	})
 }
 
 var benchErr error
@@ -342,13 +394,48 @@ func TestStreamEntry(t *T) {
 
 	assert.Equal(t, id1, entries[0].ID.String(), "parsed ID differs from ID returned by XADD")
 	assert.Equal(t, map[string]string{"hello": "world", "foo": "bar"}, entries[0].Fields)
+	assert.Equal(t, []StreamField{{"hello", "world"}, {"foo", "bar"}}, entries[0].FieldList)
 
 	assert.Equal(t, id2, entries[1].ID.String(), "parsed ID differs from ID returned by XADD")
 	assert.Equal(t, map[string]string{"hello": "bar"}, entries[1].Fields)
+	assert.Equal(t, []StreamField{{"hello", "bar"}}, entries[1].FieldList)
 
 	assert.True(t, entries[0].ID.Before(entries[1].ID))
 }
 
+func TestStreamEntryDecode(t *T) {
+	type testEntry struct {
+		Foo string
+		Bar int    `redis:"bar"`
+		Baz string `redis:"-"`
+	}
+
+	var entry StreamEntry
+	raw := "*2\r\n$3\r\n1-2\r\n*6\r\n$3\r\nFoo\r\n$1\r\na\r\n$3\r\nbar\r\n$1\r\n1\r\n$3\r\nbar\r\n$1\r\n2\r\n"
+	require.NoError(t, entry.UnmarshalRESP(bufio.NewReader(strings.NewReader(raw))))
+
+	assert.Equal(t, StreamEntryID{Time: 1, Seq: 2}, entry.ID)
+	assert.Equal(t, map[string]string{"Foo": "a", "bar": "2"}, entry.Fields)
+	assert.Equal(t, []StreamField{{"Foo", "a"}, {"bar", "1"}, {"bar", "2"}}, entry.FieldList)
+
+	var te testEntry
+	require.NoError(t, entry.Decode(&te))
+	assert.Equal(t, testEntry{Foo: "a", Bar: 2}, te)
+
+	var m map[string]int
+	assert.Error(t, entry.Decode(&m))
+
+	var ss []string
+	require.NoError(t, entry.Decode(&ss))
+	assert.Equal(t, []string{"Foo", "a", "bar", "1", "bar", "2"}, ss)
+
+	// an entry built by hand may only have Fields set
+	te = testEntry{}
+	entry = StreamEntry{Fields: map[string]string{"Foo": "b", "bar": "3"}}
+	require.NoError(t, entry.Decode(&te))
+	assert.Equal(t, testEntry{Foo: "b", Bar: 3}, te)
+}
+
 func BenchmarkStreamEntry(b *B) {
 	c := dial()
 	defer c.Close()
@@ -811,6 +898,34 @@ func TestStreamReader(t *T) {
 	})
 }
 
+func TestStreamReaderContext(t *T) {
+	// the stub never responds to XREAD, like a real XREAD with BLOCK 0 which
+	// never receives any entries
+	conn := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		return resp2.RawMessage(nil)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewStreamReader(conn, StreamReaderOpts{
+		Streams: map[string]*StreamEntryID{randStr(): nil},
+		Block:   -1,
+		Context: ctx,
+	})
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	_, _, ok := r.Next()
+	assert.False(t, ok)
+	assert.Equal(t, context.Canceled, r.Err())
+
+	// the interrupted read must have closed the Conn
+	assert.Error(t, conn.Do(Cmd(nil, "PING")))
+
+	_, _, ok = r.Next()
+	assert.False(t, ok)
+	assert.Equal(t, context.Canceled, r.Err())
+}
+
 func BenchmarkStreamReader(b *B) {
 	c := dial()
 	defer c.Close()
@@ -911,3 +1026,113 @@ func assertConsumer(tb TB, c Client, stream, group, consumer string, pending int
 
 	assert.Failf(tb, "pending messages assertion failed", "consumer %s not in group %s for stream %s", consumer, group, stream)
 }
+
+func TestStreamWriter(t *T) {
+	type testEntry struct {
+		Foo string
+		Bar int `redis:"bar"`
+	}
+
+	t.Run("Add", func(t *T) {
+		c := dial()
+		defer c.Close()
+
+		stream := randStr()
+		w, err := NewStreamWriter(c, stream, StreamWriterOpts{})
+		require.NoError(t, err)
+
+		id1, err := w.Add(map[string]string{"hello": "world"})
+		require.NoError(t, err)
+		id2, err := w.Add(testEntry{Foo: "foo", Bar: 1})
+		require.NoError(t, err)
+
+		var entries []StreamEntry
+		require.NoError(t, c.Do(Cmd(&entries, "XRANGE", stream, "-", "+")))
+		require.Len(t, entries, 2)
+		assert.Equal(t, id1, entries[0].ID)
+		assert.Equal(t, map[string]string{"hello": "world"}, entries[0].Fields)
+		assert.Equal(t, id2, entries[1].ID)
+		assert.Equal(t, map[string]string{"Foo": "foo", "bar": "1"}, entries[1].Fields)
+	})
+
+	t.Run("AddEntries", func(t *T) {
+		c := dial()
+		defer c.Close()
+
+		stream := randStr()
+		w, err := NewStreamWriter(c, stream, StreamWriterOpts{})
+		require.NoError(t, err)
+
+		ids, err := w.AddEntries([]StreamWriterEntry{
+			{ID: &StreamEntryID{Time: 1, Seq: 1}, Fields: map[string]string{"a": "1"}},
+			{ID: &StreamEntryID{Time: 1, Seq: 2}, Fields: map[string]string{"b": "2"}},
+			{Fields: testEntry{Foo: "c", Bar: 3}},
+		})
+		require.NoError(t, err)
+		require.Len(t, ids, 3)
+		assert.Equal(t, StreamEntryID{Time: 1, Seq: 1}, ids[0])
+		assert.Equal(t, StreamEntryID{Time: 1, Seq: 2}, ids[1])
+		assert.True(t, ids[1].Before(ids[2]))
+	})
+
+	t.Run("MaxLen", func(t *T) {
+		c := dial()
+		defer c.Close()
+
+		stream, maxLen := randStr(), int64(2)
+		w, err := NewStreamWriter(c, stream, StreamWriterOpts{MaxLen: &maxLen})
+		require.NoError(t, err)
+
+		var ids []StreamEntryID
+		for i := 0; i < 4; i++ {
+			id, err := w.Add(map[string]string{"i": strconv.Itoa(i)})
+			require.NoError(t, err)
+			ids = append(ids, id)
+		}
+
+		var entries []StreamEntry
+		require.NoError(t, c.Do(Cmd(&entries, "XRANGE", stream, "-", "+")))
+		require.Len(t, entries, 2)
+		assert.Equal(t, ids[2], entries[0].ID)
+		assert.Equal(t, ids[3], entries[1].ID)
+	})
+
+	t.Run("MinID", func(t *T) {
+		c := dial()
+		defer c.Close()
+
+		stream := randStr()
+		w, err := NewStreamWriter(c, stream, StreamWriterOpts{MinID: &StreamEntryID{Time: 2}})
+		require.NoError(t, err)
+
+		_, err = w.AddEntries([]StreamWriterEntry{
+			{ID: &StreamEntryID{Time: 1}, Fields: map[string]string{"a": "1"}},
+			{ID: &StreamEntryID{Time: 2}, Fields: map[string]string{"b": "2"}},
+		})
+		require.NoError(t, err)
+
+		var entries []StreamEntry
+		require.NoError(t, c.Do(Cmd(&entries, "XRANGE", stream, "-", "+")))
+		require.Len(t, entries, 1)
+		assert.Equal(t, StreamEntryID{Time: 2}, entries[0].ID)
+	})
+
+	t.Run("NoMkStream", func(t *T) {
+		c := dial()
+		defer c.Close()
+
+		w, err := NewStreamWriter(c, randStr(), StreamWriterOpts{NoMkStream: true})
+		require.NoError(t, err)
+		_, err = w.Add(map[string]string{"a": "1"})
+		assert.Equal(t, ErrNoStream, err)
+	})
+
+	t.Run("MaxLenAndMinID", func(t *T) {
+		maxLen := int64(2)
+		_, err := NewStreamWriter(nil, randStr(), StreamWriterOpts{
+			MaxLen: &maxLen,
+			MinID:  &StreamEntryID{Time: 2},
+		})
+		assert.Equal(t, errStreamTrimOpts, err)
+	})
+}
@@ -0,0 +1,384 @@
+package radix
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vikram-suki/radix/v3/resp"
+	"github.com/vikram-suki/radix/v3/resp/resp2"
+)
+
+// StreamHandler is called by a StreamWorker for every entry read from one of
+// its streams. If it returns nil the entry is acknowledged (XACK), otherwise
+// the entry stays pending and will be retried once it is claimed again.
+type StreamHandler func(stream string, entry StreamEntry) error
+
+// StreamWorkerOpts contains the options given to NewStreamWorker.
+//
+// The required fields are Streams, Group, Consumer and Handler.
+type StreamWorkerOpts struct {
+	// StreamReaderOpts are used to create the StreamReader which reads new
+	// entries for the consumer group.
+	//
+	// The values of Streams are used as the ID at which the consumer group is
+	// created for a stream if it does not exist yet. A nil value creates the
+	// group at "$", so only entries added after the group was created are read.
+	// New entries are always read using ">".
+	//
+	// NoAck and NoBlock are ignored, and a negative Block is treated the same as
+	// the default, since the worker must periodically wake up to claim entries.
+	//
+	// If Context is set the worker stops once the Context is done, but Close
+	// must still be called.
+	StreamReaderOpts
+
+	// Handler is called for every entry read or claimed by the worker.
+	Handler StreamHandler
+
+	// ClaimInterval is the interval at which the worker looks for pending
+	// entries of other (possibly dead) consumers that have been idle for at
+	// least ClaimMinIdle and claims them using XCLAIM.
+	//
+	// The default, if ClaimInterval is 0, is 30 seconds. If ClaimInterval is
+	// negative no entries will be claimed.
+	ClaimInterval time.Duration
+
+	// ClaimMinIdle is the minimum time an entry must have been pending for
+	// before it is claimed.
+	//
+	// The default, if ClaimMinIdle is 0, is 1 minute.
+	ClaimMinIdle time.Duration
+
+	// ClaimCount is the maximum number of pending entries inspected by a
+	// single XPENDING call.
+	//
+	// The default, if ClaimCount is 0, is 100.
+	ClaimCount int
+
+	// MaxDeliveries is the number of times an entry can be delivered before
+	// it is moved to DeadLetterStream instead of being claimed again.
+	//
+	// If MaxDeliveries is 0 entries are claimed indefinitely.
+	MaxDeliveries int
+
+	// DeadLetterStream is the stream that entries exceeding MaxDeliveries are
+	// added to (with a new ID but the same fields, in the same order) before
+	// they are acknowledged in their original stream.
+	//
+	// If DeadLetterStream is empty entries exceeding MaxDeliveries are only
+	// acknowledged and thus dropped.
+	DeadLetterStream string
+
+	// ErrorBackoff is how long the worker waits before reading new entries
+	// again after reading failed. Close interrupts the wait.
+	//
+	// The default, if ErrorBackoff is 0, is 1 second.
+	ErrorBackoff time.Duration
+}
+
+// StreamWorker reads entries from one or more streams as part of a consumer
+// group, passes them to a StreamHandler and acknowledges them once they were
+// handled. In the background it also claims entries that other consumers in
+// the group failed to acknowledge, and moves entries that can't be handled
+// into a dead-letter stream.
+//
+// A StreamWorker handles entries sequentially in a single go-routine. To
+// handle entries concurrently create multiple StreamWorkers with different
+// consumer names.
+type StreamWorker struct {
+	c    Client
+	opts StreamWorkerOpts
+
+	streams []string
+
+	// Any errors encountered internally, including errors returned by the
+	// Handler, will be written to this channel. If nothing is reading the
+	// channel the errors will be dropped. The channel will be closed when Close
+	// is called.
+	ErrCh chan error
+
+	cancel    context.CancelFunc
+	closeCh   chan struct{}
+	closeWG   sync.WaitGroup
+	closeOnce sync.Once
+}
+
+var errStreamWorkerOpts = errors.New("Streams, Group, Consumer and Handler are required for a StreamWorker")
+
+// NewStreamWorker creates the consumer group given in opts for each stream,
+// if it doesn't exist yet, and starts a StreamWorker which handles entries in
+// the background until Close is called.
+//
+// Any changes on opts after calling NewStreamWorker will have no effect.
+func NewStreamWorker(c Client, opts StreamWorkerOpts) (*StreamWorker, error) {
+	if len(opts.Streams) == 0 || opts.Group == "" || opts.Consumer == "" || opts.Handler == nil {
+		return nil, errStreamWorkerOpts
+	}
+
+	sw := &StreamWorker{
+		c:       c,
+		opts:    opts,
+		ErrCh:   make(chan error, 1),
+		closeCh: make(chan struct{}),
+	}
+
+	if sw.opts.ClaimInterval == 0 {
+		sw.opts.ClaimInterval = 30 * time.Second
+	}
+	if sw.opts.ClaimMinIdle == 0 {
+		sw.opts.ClaimMinIdle = 1 * time.Minute
+	}
+	if sw.opts.ClaimCount == 0 {
+		sw.opts.ClaimCount = 100
+	}
+	if sw.opts.ErrorBackoff == 0 {
+		sw.opts.ErrorBackoff = 1 * time.Second
+	}
+	if sw.opts.Block < 0 {
+		sw.opts.Block = 0
+	}
+	sw.opts.NoAck, sw.opts.NoBlock = false, false
+
+	// the reader uses its own Context, so that Close can interrupt it while
+	// it's blocked reading new entries.
+	ctx := sw.opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	sw.opts.Context, sw.cancel = context.WithCancel(ctx)
+
+	sw.streams = make([]string, 0, len(opts.Streams))
+	for stream, id := range opts.Streams {
+		sw.streams = append(sw.streams, stream)
+
+		groupID := StreamLastID
+		if id != nil {
+			groupID = id.String()
+		}
+		if err := sw.createGroup(stream, groupID); err != nil {
+			sw.cancel()
+			return nil, err
+		}
+	}
+
+	// the reader gets its own copy of the streams so that it always reads new
+	// entries using ">".
+	sw.opts.Streams = make(map[string]*StreamEntryID, len(sw.streams))
+	for _, stream := range sw.streams {
+		sw.opts.Streams[stream] = nil
+	}
+
+	sw.closeWG.Add(1)
+	go sw.spin()
+	return sw, nil
+}
+
+func (sw *StreamWorker) createGroup(stream, id string) error {
+	err := sw.c.Do(Cmd(nil, "XGROUP", "CREATE", stream, sw.opts.Group, id, "MKSTREAM"))
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+func (sw *StreamWorker) err(err error) {
+	select {
+	case sw.ErrCh <- err:
+	default:
+	}
+}
+
+func (sw *StreamWorker) spin() {
+	defer sw.closeWG.Done()
+
+	r := NewStreamReader(sw.c, sw.opts.StreamReaderOpts)
+
+	// lastClaim is left zero so that entries left over from a previous run are
+	// claimed right away.
+	var lastClaim time.Time
+	for {
+		select {
+		case <-sw.closeCh:
+			return
+		default:
+		}
+
+		if sw.opts.ClaimInterval > 0 && time.Since(lastClaim) >= sw.opts.ClaimInterval {
+			for _, stream := range sw.streams {
+				if err := sw.claim(stream); err != nil {
+					sw.err(err)
+				}
+			}
+			lastClaim = time.Now()
+		}
+
+		stream, entries, ok := r.Next()
+		if !ok && sw.opts.Context.Err() != nil {
+			return
+		} else if !ok {
+			sw.err(r.Err())
+			// back off so we don't end up in a tight loop
+			if !sw.backoff() {
+				return
+			}
+			r = NewStreamReader(sw.c, sw.opts.StreamReaderOpts)
+			continue
+		}
+
+		sw.handle(stream, entries)
+	}
+}
+
+// backoff waits for the ErrorBackoff, and returns false if the worker was
+// stopped in the meantime.
+func (sw *StreamWorker) backoff() bool {
+	t := getTimer(sw.opts.ErrorBackoff)
+	defer putTimer(t)
+	select {
+	case <-t.C:
+		return true
+	case <-sw.opts.Context.Done():
+		return false
+	}
+}
+
+// handle passes each entry to the Handler and acknowledges all entries that
+// were handled successfully using a single XACK.
+func (sw *StreamWorker) handle(stream string, entries []StreamEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	args := make([]string, 0, 2+len(entries))
+	args = append(args, stream, sw.opts.Group)
+	for _, entry := range entries {
+		if err := sw.opts.Handler(stream, entry); err != nil {
+			sw.err(err)
+			continue
+		}
+		args = append(args, entry.ID.String())
+	}
+
+	if len(args) == 2 {
+		return
+	} else if err := sw.c.Do(Cmd(nil, "XACK", args...)); err != nil {
+		sw.err(err)
+	}
+}
+
+// claim inspects all pending entries of the stream and either claims the ones
+// which have been idle for long enough or moves them to the dead-letter stream.
+func (sw *StreamWorker) claim(stream string) error {
+	minIdle := strconv.FormatInt(int64(sw.opts.ClaimMinIdle/time.Millisecond), 10)
+
+	// the idle time is checked here instead of passing IDLE to XPENDING, which
+	// is only supported by Redis 6.2+.
+	pendingOpts := StreamPendingOpts{Count: sw.opts.ClaimCount}
+	for {
+		pending, err := XPendingEntries(sw.c, stream, sw.opts.Group, pendingOpts)
+		if err != nil {
+			return err
+		}
+
+		args := []string{stream, sw.opts.Group, sw.opts.Consumer, minIdle}
+		for _, pe := range pending {
+			if pe.Idle < sw.opts.ClaimMinIdle {
+				continue
+			} else if sw.opts.MaxDeliveries > 0 && pe.Deliveries >= sw.opts.MaxDeliveries {
+				if err := sw.deadLetter(stream, pe.ID); err != nil {
+					return err
+				}
+				continue
+			}
+			args = append(args, pe.ID.String())
+		}
+
+		if len(args) > 4 {
+			var claimed streamClaimedEntries
+			if err := sw.c.Do(Cmd(&claimed, "XCLAIM", args...)); err != nil {
+				return err
+			}
+			sw.handle(stream, claimed)
+		}
+
+		if len(pending) < sw.opts.ClaimCount {
+			return nil
+		}
+		next := pending[len(pending)-1].ID.Next()
+		pendingOpts.Start = &next
+	}
+}
+
+// deadLetter copies the entry with the given ID into the DeadLetterStream, if
+// one is set, and acknowledges it.
+func (sw *StreamWorker) deadLetter(stream string, id StreamEntryID) error {
+	if sw.opts.DeadLetterStream != "" {
+		var entries []StreamEntry
+		if err := sw.c.Do(Cmd(&entries, "XRANGE", stream, id.String(), id.String())); err != nil {
+			return err
+		}
+
+		// the entry might have been deleted in the meantime, in which case
+		// there is nothing to copy but it must still be acknowledged.
+		if len(entries) > 0 {
+			args := make([]string, 0, 2+len(entries[0].FieldList)*2)
+			args = append(args, sw.opts.DeadLetterStream, StreamAutoID)
+			for _, f := range entries[0].FieldList {
+				args = append(args, f.Name, f.Value)
+			}
+			if err := sw.c.Do(Cmd(nil, "XADD", args...)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return sw.c.Do(Cmd(nil, "XACK", stream, sw.opts.Group, id.String()))
+}
+
+// Close stops the StreamWorker and waits for the entry currently being
+// handled, if any, to be finished. Close does not close the underlying Client.
+func (sw *StreamWorker) Close() error {
+	closeErr := errClientClosed
+	sw.closeOnce.Do(func() {
+		close(sw.closeCh)
+		sw.cancel()
+		sw.closeWG.Wait()
+		close(sw.ErrCh)
+		closeErr = nil
+	})
+	return closeErr
+}
+
+// streamClaimedEntries holds the entries returned by XCLAIM. Depending on the
+// Redis version entries which were deleted in the meantime are returned as
+// nil, these are skipped.
+type streamClaimedEntries []StreamEntry
+
+var _ resp.Unmarshaler = (*streamClaimedEntries)(nil)
+
+func (sce *streamClaimedEntries) UnmarshalRESP(br *bufio.Reader) error {
+	var rms []resp2.RawMessage
+	if err := (resp2.Any{I: &rms}).UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	entries := (*sce)[:0]
+	for _, rm := range rms {
+		if rm.IsNil() {
+			continue
+		}
+
+		var entry StreamEntry
+		if err := rm.UnmarshalInto(&entry); err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+	*sce = entries
+	return nil
+}
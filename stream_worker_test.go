@@ -0,0 +1,147 @@
+package radix
+
+import (
+	"errors"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vikram-suki/radix/v3/resp/resp2"
+)
+
+func TestStreamWorker(t *T) {
+	t.Run("CloseDuringBackoff", func(t *T) {
+		c := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+			if args[0] == "XREADGROUP" {
+				return resp2.Error{E: errors.New("ERR stub")}
+			}
+			return resp2.SimpleString{S: "OK"}
+		})
+
+		sw, err := NewStreamWorker(c, StreamWorkerOpts{
+			StreamReaderOpts: StreamReaderOpts{
+				Streams:  map[string]*StreamEntryID{"stream": nil},
+				Group:    "group",
+				Consumer: "consumer",
+			},
+			Handler:       func(string, StreamEntry) error { return nil },
+			ClaimInterval: -1,
+			ErrorBackoff:  time.Hour,
+		})
+		require.NoError(t, err)
+
+		// once the read failed the worker backs off, which Close interrupts
+		assert.EqualError(t, <-sw.ErrCh, "ERR stub")
+		closedCh := make(chan error)
+		go func() { closedCh <- sw.Close() }()
+		select {
+		case err := <-closedCh:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("Close didn't interrupt the backoff")
+		}
+	})
+
+	t.Run("Ack", func(t *T) {
+		c := dial()
+		defer c.Close()
+
+		stream, group := randStr(), randStr()
+		entryCh := make(chan StreamEntry, 1)
+		sw, err := NewStreamWorker(c, StreamWorkerOpts{
+			StreamReaderOpts: StreamReaderOpts{
+				Streams:  map[string]*StreamEntryID{stream: nil},
+				Group:    group,
+				Consumer: randStr(),
+				Block:    100 * time.Millisecond,
+			},
+			Handler: func(_ string, entry StreamEntry) error {
+				entryCh <- entry
+				return nil
+			},
+		})
+		require.NoError(t, err)
+
+		id := addStreamEntry(t, c, stream)
+		assert.Equal(t, id, (<-entryCh).ID)
+		require.NoError(t, sw.Close())
+
+		pending, err := XPending(c, stream, group)
+		require.NoError(t, err)
+		assert.Zero(t, pending.Count)
+	})
+
+	t.Run("Claim", func(t *T) {
+		c := dial()
+		defer c.Close()
+
+		stream, group := randStr(), randStr()
+		addStreamGroup(t, c, stream, group, "0-0")
+		id := addStreamEntry(t, c, stream)
+
+		// read the entry with a consumer which never acknowledges it
+		require.NoError(t, c.Do(Cmd(nil, "XREADGROUP", "GROUP", group, randStr(), "STREAMS", stream, ">")))
+
+		entryCh := make(chan StreamEntry, 1)
+		sw, err := NewStreamWorker(c, StreamWorkerOpts{
+			StreamReaderOpts: StreamReaderOpts{
+				Streams:  map[string]*StreamEntryID{stream: nil},
+				Group:    group,
+				Consumer: randStr(),
+				Block:    100 * time.Millisecond,
+			},
+			Handler: func(_ string, entry StreamEntry) error {
+				entryCh <- entry
+				return nil
+			},
+			ClaimInterval: 50 * time.Millisecond,
+			ClaimMinIdle:  time.Millisecond,
+		})
+		require.NoError(t, err)
+		defer sw.Close()
+
+		assert.Equal(t, id, (<-entryCh).ID)
+	})
+
+	t.Run("DeadLetter", func(t *T) {
+		c := dial()
+		defer c.Close()
+
+		stream, group, deadStream := randStr(), randStr(), randStr()
+		handlerErr := errors.New("handler failed")
+		sw, err := NewStreamWorker(c, StreamWorkerOpts{
+			StreamReaderOpts: StreamReaderOpts{
+				Streams:  map[string]*StreamEntryID{stream: nil},
+				Group:    group,
+				Consumer: randStr(),
+				Block:    100 * time.Millisecond,
+			},
+			Handler: func(string, StreamEntry) error {
+				return handlerErr
+			},
+			ClaimInterval:    50 * time.Millisecond,
+			ClaimMinIdle:     time.Millisecond,
+			MaxDeliveries:    2,
+			DeadLetterStream: deadStream,
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, c.Do(Cmd(nil, "XADD", stream, "*", "foo", "bar")))
+		assert.Equal(t, handlerErr, <-sw.ErrCh)
+
+		var entries []StreamEntry
+		for i := 0; i < 50 && len(entries) == 0; i++ {
+			time.Sleep(50 * time.Millisecond)
+			require.NoError(t, c.Do(Cmd(&entries, "XRANGE", deadStream, "-", "+")))
+		}
+		require.NoError(t, sw.Close())
+
+		require.Len(t, entries, 1)
+		assert.Equal(t, map[string]string{"foo": "bar"}, entries[0].Fields)
+
+		pending, err := XPending(c, stream, group)
+		require.NoError(t, err)
+		assert.Zero(t, pending.Count)
+	})
+}
@@ -168,7 +168,9 @@ type stub struct {
 // When Encode is called the given value is marshalled into bytes then
 // unmarshalled into a []string, which is passed to the callback. The return
 // from the callback is then marshalled and buffered interanlly, and will be
-// unmarshalled in the next call to Decode.
+// unmarshalled in the next call to Decode. If a single Encode call encodes
+// multiple commands, e.g. when performing a Pipeline, the callback is called
+// for each of them in turn.
 //
 // remoteNetwork and remoteAddr can be empty, but if given will be used as the
 // return from the RemoteAddr method.
@@ -212,9 +214,13 @@ func (s *stub) Encode(m resp.Marshaler) error {
 		// get return from callback. Results implementing resp.Marshaler are
 		// assumed to be wanting to be written in all cases, otherwise if the
 		// result is an error it is assumed to want to be returned directly.
+		// Either way the remaining commands, e.g. of a pipeline, are still
+		// handled if the result was written.
 		ret := s.fn(ss)
 		if m, ok := ret.(resp.Marshaler); ok {
-			return s.buffer.Encode(m)
+			if err := s.buffer.Encode(m); err != nil {
+				return err
+			}
 		} else if err, _ := ret.(error); err != nil {
 			return err
 		} else if err = s.buffer.Encode(resp2.Any{I: ret}); err != nil {
@@ -1,6 +1,7 @@
 package radix
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
@@ -62,6 +63,26 @@ func TestStubPipeline(t *T) {
 	assert.Equal(t, "bar", out)
 }
 
+func TestStubPipelineMarshaler(t *T) {
+	// a result implementing resp.Marshaler, like resp2.Error, must not cause
+	// the following commands of the pipeline to be dropped
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		if args[0] == "ERR" {
+			return resp2.Error{E: errors.New("ERR stub")}
+		}
+		return resp2.SimpleString{S: args[0]}
+	})
+
+	var out string
+	err := stub.Do(Pipeline(Cmd(nil, "ERR"), Cmd(&out, "PING")))
+	assert.Equal(t, resp2.Error{E: errors.New("ERR stub")}, err)
+
+	// the pipeline stops at the first error, so the reply to PING is left
+	require.NoError(t, stub.NetConn().SetReadDeadline(time.Now().Add(time.Second)))
+	require.NoError(t, stub.Decode(resp2.Any{I: &out}))
+	assert.Equal(t, "PING", out)
+}
+
 func TestStubLockingTimeout(t *T) {
 	stub := testStub()
 	wg := new(sync.WaitGroup)
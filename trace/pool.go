@@ -0,0 +1,204 @@
+// Package trace contains the types used for tracing the internal behavior of
+// the Clients in the radix package, e.g. for exporting metrics or for
+// debugging. All callbacks are optional, and are called synchronously from
+// within the Client, so they should return quickly.
+package trace
+
+import "time"
+
+// PoolTrace contains callbacks which are called on specific events happening
+// within a Pool. All of them may be called concurrently.
+type PoolTrace struct {
+	// ConnCreated is called when the Pool attempted to create a new
+	// connection, whether it succeeded or not.
+	ConnCreated func(PoolConnCreated)
+
+	// ConnClosed is called when the Pool closed one of its connections.
+	ConnClosed func(PoolConnClosed)
+
+	// GetCompleted is called when a connection was retrieved from the Pool in
+	// order to perform an Action on it, or if that failed.
+	GetCompleted func(PoolGetCompleted)
+
+	// EmptyTimeout is called when the Pool was empty and no connection became
+	// available within the configured wait time.
+	EmptyTimeout func(PoolEmptyTimeout)
+
+	// OverflowUsed is called when a connection was put into the overflow
+	// buffer of the Pool, because the Pool was otherwise full.
+	OverflowUsed func(PoolOverflowUsed)
+
+	// PingFailed is called when the periodic PING of one of the Pool's
+	// connections failed.
+	PingFailed func(PoolPingFailed)
+
+	// PipelineFlushed is called when the Pool's automatic pipelining
+	// performed a pipeline.
+	PipelineFlushed func(PoolPipelineFlushed)
+
+	// CircuitChanged is called when the circuit breaker of the Pool opened or
+	// closed.
+	CircuitChanged func(PoolCircuitChanged)
+}
+
+// PoolCommon contains information which is passed to all PoolTrace callbacks.
+type PoolCommon struct {
+	// Network and Addr are the arguments the Pool was created with.
+	Network, Addr string
+
+	// PoolSize and BufferSize are the size of the Pool and of its overflow
+	// buffer.
+	PoolSize, BufferSize int
+}
+
+// PoolConnCreatedReason describes why a Pool created a new connection.
+type PoolConnCreatedReason string
+
+// All possible values of PoolConnCreatedReason.
+const (
+	// PoolConnCreatedReasonInitialization means the connection was created
+	// while the Pool was being initialized.
+	PoolConnCreatedReasonInitialization PoolConnCreatedReason = "initialization"
+
+	// PoolConnCreatedReasonRefill means the connection was created by the
+	// refill, because the Pool had less than its size.
+	PoolConnCreatedReasonRefill PoolConnCreatedReason = "refill"
+
+	// PoolConnCreatedReasonPoolEmpty means the connection was created because
+	// the Pool had no available connections.
+	PoolConnCreatedReasonPoolEmpty PoolConnCreatedReason = "pool empty"
+)
+
+// PoolConnCreated is passed to the ConnCreated callback.
+type PoolConnCreated struct {
+	PoolCommon
+
+	Reason PoolConnCreatedReason
+
+	// ConnectTime is how long it took to create the connection.
+	ConnectTime time.Duration
+
+	// Err is the error creating the connection failed with, if any.
+	Err error
+}
+
+// PoolConnClosedReason describes why a Pool closed a connection.
+type PoolConnClosedReason string
+
+// All possible values of PoolConnClosedReason.
+const (
+	// PoolConnClosedReasonPoolClosed means the connection was closed because
+	// the Pool was closed.
+	PoolConnClosedReasonPoolClosed PoolConnClosedReason = "pool closed"
+
+	// PoolConnClosedReasonBufferDrain means the connection was closed by the
+	// periodic drain of the overflow buffer.
+	PoolConnClosedReasonBufferDrain PoolConnClosedReason = "buffer drained"
+
+	// PoolConnClosedReasonPoolFull means the connection was closed when being
+	// returned to the Pool, because the Pool and its overflow buffer were
+	// full.
+	PoolConnClosedReasonPoolFull PoolConnClosedReason = "pool full"
+
+	// PoolConnClosedReasonConnError means the connection was closed because
+	// of a network error on it.
+	PoolConnClosedReasonConnError PoolConnClosedReason = "conn error"
+
+	// PoolConnClosedReasonMaxLifetime means the connection was closed because
+	// it was older than the Pool's max connection lifetime.
+	PoolConnClosedReasonMaxLifetime PoolConnClosedReason = "max lifetime"
+
+	// PoolConnClosedReasonMaxIdleTime means the connection was closed because
+	// it was unused for longer than the Pool's max idle time.
+	PoolConnClosedReasonMaxIdleTime PoolConnClosedReason = "max idle time"
+
+	// PoolConnClosedReasonDirtyState means the connection was closed because
+	// it was left in a state which couldn't be reset, e.g. subscribed or with
+	// a different DB selected.
+	PoolConnClosedReasonDirtyState PoolConnClosedReason = "dirty state"
+
+	// PoolConnClosedReasonPoolResized means the connection was closed because
+	// it didn't fit into the Pool anymore after its size was decreased.
+	PoolConnClosedReasonPoolResized PoolConnClosedReason = "pool resized"
+
+	// PoolConnClosedReasonCircuitOpen means the connection was closed because
+	// the Pool's circuit breaker opened, and the connection was likely broken.
+	PoolConnClosedReasonCircuitOpen PoolConnClosedReason = "circuit open"
+)
+
+// PoolConnClosed is passed to the ConnClosed callback.
+type PoolConnClosed struct {
+	PoolCommon
+
+	// AvailCount is the number of connections available in the Pool after the
+	// connection was closed.
+	AvailCount int
+
+	Reason PoolConnClosedReason
+
+	// Err is the network error which caused the connection to be closed, if
+	// Reason is PoolConnClosedReasonConnError.
+	Err error
+}
+
+// PoolGetCompleted is passed to the GetCompleted callback.
+type PoolGetCompleted struct {
+	PoolCommon
+
+	// WaitTime is how long was waited for a connection to become available.
+	// It's zero if a connection was available right away.
+	WaitTime time.Duration
+
+	// Err is the error retrieving a connection failed with, if any.
+	Err error
+}
+
+// PoolEmptyTimeout is passed to the EmptyTimeout callback.
+type PoolEmptyTimeout struct {
+	PoolCommon
+
+	// WaitTime is how long was waited for a connection to become available.
+	WaitTime time.Duration
+
+	// Err is the error which is returned because of the timeout, or nil if a
+	// new connection is created instead.
+	Err error
+}
+
+// PoolOverflowUsed is passed to the OverflowUsed callback.
+type PoolOverflowUsed struct {
+	PoolCommon
+
+	// OverflowCount is the number of connections in the overflow buffer after
+	// the connection was put into it.
+	OverflowCount int
+}
+
+// PoolPingFailed is passed to the PingFailed callback.
+type PoolPingFailed struct {
+	PoolCommon
+	Err error
+}
+
+// PoolPipelineFlushed is passed to the PipelineFlushed callback.
+type PoolPipelineFlushed struct {
+	PoolCommon
+
+	// NumCmds is the number of commands which were part of the pipeline.
+	NumCmds int
+
+	// Err is the error performing the pipeline failed with, if any. Errors
+	// returned by single commands are not included.
+	Err error
+}
+
+// PoolCircuitChanged is passed to the CircuitChanged callback.
+type PoolCircuitChanged struct {
+	PoolCommon
+
+	// Open is whether the circuit is open now.
+	Open bool
+
+	// Err is the last error which caused the circuit to open, if Open is true.
+	Err error
+}